@@ -3,19 +3,25 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/hpe-storage/common-host-libs/chapi2/chapiclient"
 	log "github.com/hpe-storage/common-host-libs/logger"
+	"github.com/hpe-storage/common-host-libs/util"
 )
 
 // getLogPath returns the folder where this application's log file will be stored
 func getLogPath() string {
-	// TODO
-	log.Fatal("getLogPath not implemented")
-	return ""
+	return util.GetNltHome() + "log/"
 }
 
+// getChapiClient allocates and returns a new CHAPI2 Client access object
 func getChapiClient() (chapiClient *chapiclient.Client, err error) {
-	// TODO
-	log.Fatal("getChapiClient not implemented")
-	return nil, nil
+	if chapiClient, err = chapiclient.NewChapiClient(); err != nil {
+		log.Errorf("Failed getChapiClient, err=%v", err)
+		fmt.Println(`Unable to get CHAPI client object.  Make sure the chapid service is running.`)
+		fmt.Printf("\nError: %v\n", err)
+		return nil, err
+	}
+	return chapiClient, err
 }
@@ -4,6 +4,8 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
+	"github.com/hpe-storage/common-host-libs/chapi"
 	log "github.com/hpe-storage/common-host-libs/logger"
 	"github.com/hpe-storage/common-host-libs/model"
 	"github.com/hpe-storage/common-host-libs/util"
@@ -13,12 +15,16 @@ import (
 )
 
 var (
-	baseURL        = "http://localhost:9007/hosts"
+	baseURL        = flag.String("url", "https://localhost:9007/hosts", "Hosts URL of the chapid TLS listener")
+	certFile       = flag.String("cert", "", "Client certificate presented to chapid")
+	keyFile        = flag.String("key", "", "Private key of the client certificate")
+	serverCertFile = flag.String("server-cert", chapi.ChapidCertFile, "Host certificate of chapid")
 	chapiClientLog = util.GetNltHome() + "log/chapiclient.log"
 )
 
 //TODO convert this to use testing framework
 func main() {
+	flag.Parse()
 	log.InitLogging(chapiClientLog, &log.LogParams{Level: "trace"}, false)
 
 	var hosts model.Hosts
@@ -31,23 +37,31 @@ func main() {
 	var restClient = &http.Client{
 		Timeout: time.Second * 60,
 	}
+	if *certFile != "" {
+		// authenticate with chapid using the client certificate
+		tlsConfig, err := chapi.NewTLSClientConfig(*certFile, *keyFile, *serverCertFile)
+		if err != nil {
+			return
+		}
+		restClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
 
 	// Retrieve the Host UUID
-	response, err := restClient.Get(baseURL)
+	response, err := restClient.Get(*baseURL)
 	if err != nil {
 		return
 	}
 	defer response.Body.Close()
 	buf, _ := ioutil.ReadAll(response.Body)
 	json.Unmarshal(buf, &hosts)
-	log.Tracef("URL:%s StatusCode:%d UUID:%s", baseURL, response.StatusCode, hosts[0].UUID)
+	log.Tracef("URL:%s StatusCode:%d UUID:%s", *baseURL, response.StatusCode, hosts[0].UUID)
 
-	buffer.WriteString(baseURL)
+	buffer.WriteString(*baseURL)
 	buffer.WriteString("/")
 	buffer.WriteString(hosts[0].UUID)
 	buffer.WriteString("/devices")
 	devicesURL := buffer.String()
-	log.Tracef("URL:%s StatusCode:%d", baseURL, response.StatusCode)
+	log.Tracef("URL:%s StatusCode:%d", *baseURL, response.StatusCode)
 
 	// Retrive the Host Devices
 	response, err = restClient.Get(devicesURL)
@@ -73,7 +87,7 @@ func main() {
 		return
 	}
 	defer response.Body.Close()
-	log.Tracef("URL:%s StatusCode:%d", baseURL, response.StatusCode)
+	log.Tracef("URL:%s StatusCode:%d", *baseURL, response.StatusCode)
 	buf, _ = ioutil.ReadAll(response.Body)
 	json.Unmarshal(buf, &device)
 	log.Tracef("Device: %#v", device)
@@ -89,7 +103,7 @@ func main() {
 		return
 	}
 	defer response.Body.Close()
-	log.Tracef("URL:%s StatusCode:%d", baseURL, response.StatusCode)
+	log.Tracef("URL:%s StatusCode:%d", *baseURL, response.StatusCode)
 	buf, _ = ioutil.ReadAll(response.Body)
 	json.Unmarshal(buf, &devicePartitions)
 	for _, part := range devicePartitions {
@@ -2,12 +2,22 @@ package main
 
 // Copyright 2019 Hewlett Packard Enterprise Development LP.
 import (
+	"flag"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 
+	"github.com/gorilla/mux"
 	"github.com/hpe-storage/common-host-libs/chapi"
+	"github.com/hpe-storage/common-host-libs/chapi2"
+	"github.com/hpe-storage/common-host-libs/chapi2/cerrors"
+	"github.com/hpe-storage/common-host-libs/linux"
 	log "github.com/hpe-storage/common-host-libs/logger"
+	"github.com/hpe-storage/common-host-libs/openapi"
 	"github.com/hpe-storage/common-host-libs/util"
 )
 
@@ -17,24 +27,204 @@ var (
 	// Commit containers the hg commit added by the build process
 	Commit    = "unknown"
 	chapidLog = util.GetNltHome() + "log/chapid.log"
+
+	listenAddress  = flag.String("listen", "", "Address (host:port) of the optional mutual TLS listener, disabled if empty")
+	certFile       = flag.String("cert", chapi.ChapidCertFile, "Host certificate of the TLS listener, generated if not present")
+	keyFile        = flag.String("key", chapi.ChapidKeyFile, "Private key of the host certificate, generated if not present")
+	clientsFile    = flag.String("clients", chapi.ChapidClientsFile, "PEM bundle of client certificates, or their CAs, allowed to connect to the TLS listener")
+	allowedClients = flag.String("allowed-clients", "", "Comma separated common names of the client certificates allowed to connect to the TLS listener")
+	drainTimeout   = flag.Duration("drain-timeout", chapi.DefaultDrainTimeout, "Time to wait for in-flight requests to complete on shutdown")
+	deviceEvents   = flag.Bool("device-events", true, "Keep the devices up to date from the kernel uevents instead of discovering them on each request")
+
+	chapidServer *http.Server
+	tlsServer    *http.Server
+	tlsListener  *chapi.TLSListener
+	inventory    *linux.DeviceInventory
 )
 
 func main() {
+	flag.Parse()
 	log.InitLogging(chapidLog, &log.LogParams{Level: "trace"}, false)
 	log.Infof("Starting chapi server version %s(%s)...", Version, Commit)
-	nimbledChan := make(chan error)
-	chapi.RunNimbled(nimbledChan)
+	nimbledChan := make(chan error, 2)
+	// report the operations interrupted by the previous run as failed right away
+	chapi.LoadOperations()
+	if *deviceEvents {
+		runDeviceInventory()
+	}
+	runChapid(nimbledChan)
+	if *listenAddress != "" {
+		runChapidTLS(nimbledChan)
+	}
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
 		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
+	for {
+		select {
+		case s := <-sigc:
+			if s == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			log.Infof("Shutting down due to signal notification.  Signal was %v.", s.String())
+		case x := <-nimbledChan:
+			log.Error("error on chapid socket:", x)
+		}
+		shutdown()
+		return
+	}
+}
+
+// reload reloads the certificates of the TLS listener without interrupting the served requests
+func reload() {
+	if tlsListener == nil {
+		log.Info("Received SIGHUP, no configuration to reload")
+		return
+	}
+	log.Info("Received SIGHUP, reloading TLS certificates")
+	if err := tlsListener.Reload(); err != nil {
+		log.Errorf("unable to reload TLS certificates, retaining the current configuration, err %s", err.Error())
+	}
+}
+
+// shutdown stops accepting new requests and waits for in-flight requests to drain on all listeners
+func shutdown() {
+	var wg sync.WaitGroup
+	for _, server := range []*http.Server{chapidServer, tlsServer} {
+		if server == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			chapi.ShutdownServer(server, *drainTimeout)
+		}(server)
+	}
+	wg.Wait()
+	// the device operations of both listeners are waited for once no listener accepts requests
+	chapi.WaitForOperations()
+	if inventory != nil {
+		linux.SetDeviceInventory(nil)
+		inventory.Stop()
+	}
+	os.RemoveAll(chapi.ChapidSocketPath + chapi.ChapidSocketName)
+	log.Info("chapid shutdown complete")
+}
+
+// newRouter returns a handler serving both the CHAPI (/hosts/...) and CHAPI2 (/api/v1/...) routes.
+// Requests that do not match a CHAPI route fall through to the CHAPI2 router.
+func newRouter() http.Handler {
+	router := chapi.NewRouter()
+	chapi2Router := chapi2.NewRouter()
+	// CHAPI2 device attach and detach must complete, or roll back, before chapid exits
+	for _, name := range []string{"CreateDevice", "DeleteDevice"} {
+		route := chapi2Router.Get(name)
+		route.Handler(chapi.TrackOperations(route.GetHandler()))
+	}
+	router.NotFoundHandler = chapi2Router
+
+	// the OpenAPI document of chapid covers both route tables
+	chapidRouter := mux.NewRouter().StrictSlash(true)
+	util.InitializeRouter(chapidRouter, []util.Route{
+		util.Route{
+			Name:        "OpenAPI",
+			Method:      "GET",
+			Pattern:     openapi.Pattern,
+			HandlerFunc: serveOpenAPI,
+		},
+	})
+	chapidRouter.NotFoundHandler = router
+	return chapidRouter
+}
+
+// getOpenAPIDocument returns the OpenAPI document of the CHAPI and CHAPI2 routes served by chapid
+func getOpenAPIDocument() *openapi.Document {
+	doc := openapi.NewDocument("chapid", "1.0.0")
+	doc.AddRoutes(chapi.GetRoutes(), &chapi.ErrorResponse{})
+	doc.AddRoutes(chapi2.GetRoutes(), &cerrors.ChapiError{})
+	return doc
+}
+
+// serveOpenAPI serves the OpenAPI document of chapid
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	getOpenAPIDocument().ServeHTTP(w, r)
+}
+
+// runDeviceInventory discovers the devices and keeps them up to date from the kernel uevents. Devices
+// are discovered on each request when the uevents cannot be received, eg without CAP_NET_ADMIN.
+func runDeviceInventory() {
+	deviceInventory := linux.NewDeviceInventory()
+	if err := deviceInventory.Start(); err != nil {
+		log.Warnf("unable to start the device inventory, devices are discovered on each request, err %s", err.Error())
+		return
+	}
+	inventory = deviceInventory
+	linux.SetDeviceInventory(inventory)
+}
+
+// runChapid listens on the chapid socket and serves the CHAPI and CHAPI2 routes.  The result of
+// Serve is sent on the given channel once the server exits.
+func runChapid(c chan error) {
+	socket := chapi.ChapidSocketPath + chapi.ChapidSocketName
+
+	// cleanup the existing socket before creating it again
+	if err := os.RemoveAll(socket); err != nil {
+		log.Fatal("Unable to cleanup existing sockets")
+	}
+
+	// first check if the directory exists
+	_, isDir, _ := util.FileExists(chapi.ChapidSocketPath)
+	if !isDir {
+		// create the directory for chapidSocket
+		if err := os.MkdirAll(chapi.ChapidSocketPath, 0700); err != nil {
+			log.Fatal("Unable to create directory " + chapi.ChapidSocketPath + " for chapid server to run")
+		}
+	}
+
+	// create chapidSocket for listening
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Fatal("listen error, Unable to create ChapidServer ", err)
+	}
+
+	chapidServer = &http.Server{Handler: newRouter()}
 	go func() {
-		s := <-sigc
-		log.Fatalf("Exiting due to signal notification.  Signal was %v.", s.String())
-		os.Exit(1)
+		log.Info("Serving socket :", listener.Addr().String())
+		c <- chapidServer.Serve(listener)
+
+		// close the socket and cleanup the socket file
+		log.Infof("closing the socket %v", listener.Addr().String())
+		listener.Close()
+		os.RemoveAll(socket)
+	}()
+}
+
+// runChapidTLS listens on the TCP address with mutual TLS and serves the CHAPI and CHAPI2 routes.
+// Only clients presenting a certificate from the allow-list are accepted.
+func runChapidTLS(c chan error) {
+	config := &chapi.TLSServerConfig{
+		CertFile:    *certFile,
+		KeyFile:     *keyFile,
+		ClientsFile: *clientsFile,
+	}
+	if *allowedClients != "" {
+		config.AllowedClients = strings.Split(*allowedClients, ",")
+	}
+	listener, err := chapi.NewTLSListener(*listenAddress, config)
+	if err != nil {
+		log.Fatal("listen error, Unable to create TLS listener for ChapidServer ", err)
+	}
+	tlsListener = listener
+
+	tlsServer = &http.Server{Handler: newRouter()}
+	go func() {
+		log.Info("Serving TLS :", listener.Addr().String())
+		c <- tlsServer.Serve(listener)
+
+		log.Infof("closing the listener %v", listener.Addr().String())
+		listener.Close()
 	}()
-	x := <-nimbledChan
-	log.Error("error on chapid socket:", x)
 }
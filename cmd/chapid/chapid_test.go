@@ -3,18 +3,31 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/hpe-storage/common-host-libs/cert"
 	"github.com/hpe-storage/common-host-libs/chapi"
+	"github.com/hpe-storage/common-host-libs/chapi2"
 	"github.com/hpe-storage/common-host-libs/jconfig"
+	log "github.com/hpe-storage/common-host-libs/logger"
 	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/openapi"
+)
+
+const (
+	// openAPIFile is the OpenAPI document chapid is expected to serve
+	openAPIFile = "openapi.json"
 )
 
 var (
@@ -36,6 +49,8 @@ var (
 	mountStruct  model.Mount
 	mountID      string
 	config       *jconfig.Config
+
+	update = flag.Bool("update", false, "Update "+openAPIFile+" with the OpenAPI document generated from the routes")
 )
 
 type Config struct {
@@ -429,3 +444,239 @@ func TestUnmount(t *testing.T) {
 		t.Errorf("No Mount point created")
 	}
 }
+
+// writeClientCert generates a client keypair in the given directory
+func writeClientCert(t *testing.T, dir string, cn string) (certFile string, keyFile string) {
+	_, keyPem, certPem, err := cert.GenerateCert(cn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	if err = ioutil.WriteFile(certFile, []byte(certPem), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, []byte(keyPem), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chapid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// both orchestrator and intruder certificates are trusted, but only orchestrator is allowed
+	clientCert, clientKey := writeClientCert(t, dir, "orchestrator")
+	intruderCert, intruderKey := writeClientCert(t, dir, "intruder")
+	otherCert, otherKey := writeClientCert(t, dir, "other")
+	clientPem, _ := ioutil.ReadFile(clientCert)
+	intruderPem, _ := ioutil.ReadFile(intruderCert)
+	clientsFile := filepath.Join(dir, "clients.pem")
+	if err = ioutil.WriteFile(clientsFile, append(clientPem, intruderPem...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &chapi.TLSServerConfig{
+		CertFile:       filepath.Join(dir, "chapid.crt"),
+		KeyFile:        filepath.Join(dir, "chapid.key"),
+		ClientsFile:    clientsFile,
+		AllowedClients: []string{"orchestrator"},
+	}
+	listener, err := chapi.NewTLSListener("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, string(hostResp))
+	}))
+	url := "https://" + listener.Addr().String() + "/hosts"
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		success  bool
+	}{
+		{"allowed client", clientCert, clientKey, true},
+		{"client not allowed", intruderCert, intruderKey, false},
+		{"unknown client", otherCert, otherKey, false},
+	}
+	for _, tc := range tests {
+		tlsConfig, err := chapi.NewTLSClientConfig(tc.certFile, tc.keyFile, config.CertFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(url)
+		if tc.success && (err != nil || resp.StatusCode != 200) {
+			t.Errorf("%s: success expected, error %v", tc.name, err)
+		} else if !tc.success && err == nil {
+			t.Errorf("%s: handshake failure expected, status %d", tc.name, resp.StatusCode)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metricsServer := httptest.NewServer(newRouter())
+	defer metricsServer.Close()
+
+	// the first scrape is recorded in the metrics returned by the second one
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(metricsServer.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		if i == 0 {
+			continue
+		}
+		for _, expected := range []string{
+			`# TYPE chapi_http_requests_total counter`,
+			`chapi_http_requests_total{route="/metrics",method="GET",code="200"} 1`,
+			`chapi_http_request_duration_seconds_count{route="/metrics",method="GET"} 1`,
+			`# TYPE chapi_device_operation_duration_seconds histogram`,
+			`chapi_deleting_devices 0`,
+		} {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("metrics do not contain %s:\n%s", expected, body)
+			}
+		}
+	}
+}
+
+func TestGetOperationNotFound(t *testing.T) {
+	operationServer := httptest.NewServer(chapi.NewRouter())
+	defer operationServer.Close()
+
+	var hosts model.Hosts
+	chapiResp := chapi.Response{Data: &hosts}
+	resp, err := http.Get(operationServer.URL + "/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&chapiResp)
+	resp.Body.Close()
+	if err != nil || len(hosts) != 1 {
+		t.Skipf("unable to get host id, err %v", err)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/hosts/%s/operations/%s", operationServer.URL, hosts[0].UUID, "unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for unknown operation, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+// TestRequestID checks that a valid X-Request-ID is echoed back in the responses and errors, and that
+// one is generated otherwise
+func TestRequestID(t *testing.T) {
+	requestIDServer := httptest.NewServer(chapi.NewRouter())
+	defer requestIDServer.Close()
+
+	var hosts model.Hosts
+	chapiResp := chapi.Response{Data: &hosts}
+	resp, err := http.Get(requestIDServer.URL + "/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&chapiResp)
+	resp.Body.Close()
+	if err != nil || len(hosts) != 1 {
+		t.Skipf("unable to get host id, err %v", err)
+	}
+	if !log.IsValidRequestID(resp.Header.Get(log.RequestIDHeader)) {
+		t.Errorf("expected a generated request id, got %q", resp.Header.Get(log.RequestIDHeader))
+	}
+
+	tests := []struct {
+		name      string
+		requestID string
+		echoed    bool
+	}{
+		{"propagated", "dockerplugin-1234", true},
+		{"invalid", "bad id{}", false},
+	}
+	for _, tc := range tests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/hosts/%s/operations/%s", requestIDServer.URL, hosts[0].UUID, "unknown"), nil)
+		req.Header.Set(log.RequestIDHeader, tc.requestID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errResp chapi.ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&chapi.Response{Err: &errResp})
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: unable to decode error response, err %v", tc.name, err)
+		}
+		responseID := resp.Header.Get(log.RequestIDHeader)
+		if (responseID == tc.requestID) != tc.echoed || !log.IsValidRequestID(responseID) {
+			t.Errorf("%s: unexpected response request id %q for %q", tc.name, responseID, tc.requestID)
+		}
+		if errResp.RequestID != responseID {
+			t.Errorf("%s: expected request id %q in the error response, got %q", tc.name, responseID, errResp.RequestID)
+		}
+	}
+}
+
+// TestOpenAPIContract fails if the routes or types served by chapid drift from openapi.json. Run
+// "go test -run TestOpenAPIContract -update" to accept the changes.
+func TestOpenAPIContract(t *testing.T) {
+	openAPIServer := httptest.NewServer(newRouter())
+	defer openAPIServer.Close()
+
+	resp, err := http.Get(openAPIServer.URL + openapi.Pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unable to get %s, status %d, err %v", openapi.Pattern, resp.StatusCode, err)
+	}
+
+	if *update {
+		if err = ioutil.WriteFile(openAPIFile, generated, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(openAPIFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, expected) {
+		t.Fatalf("generated OpenAPI document differs from %s, run \"go test -run TestOpenAPIContract -update\" if the change is intended", openAPIFile)
+	}
+
+	var doc openapi.Document
+	if err = json.Unmarshal(expected, &doc); err != nil {
+		t.Fatal(err)
+	}
+	// every route registered on the routers must be documented
+	for _, router := range []*mux.Router{chapi.NewRouter(), chapi2.NewRouter()} {
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			path, _ := route.GetPathTemplate()
+			methods, _ := route.GetMethods()
+			for _, method := range methods {
+				if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+					t.Errorf("route %s %s is not documented in %s", method, path, openAPIFile)
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/linux"
+	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/util"
+)
+
+const (
+	// hostFixtureDir holds the captured hosts driving the linux device tests
+	hostFixtureDir = "testdata/hosts"
+)
+
+// hostFixture is a captured host: its sysfs and procfs entries and the output of the commands run
+// against it. Commands are captured by running chapid with util.NewCommandRecorder as command runner
+// and saving its records with util.SaveCommandRecords.
+type hostFixture struct {
+	Description string                `json:"description"`
+	Files       map[string]string     `json:"files"`
+	Links       map[string]string     `json:"links"`
+	Commands    []*util.CommandRecord `json:"commands"`
+}
+
+// expectedDevice is the part of a discovered device checked by the tests
+type expectedDevice struct {
+	serial    string
+	mpathName string
+	size      int64
+	state     string
+	slaves    []string
+	targets   []string
+}
+
+// loadHostFixture materializes the host under a temporary root and replays its commands until the
+// end of the test
+func loadHostFixture(t *testing.T, name string) (string, *util.CommandReplayer) {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(hostFixtureDir, name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture hostFixture
+	if err = json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("unable to parse host fixture %s, err %s", name, err.Error())
+	}
+
+	root := t.TempDir()
+	for path, content := range fixture.Files {
+		file := filepath.Join(root, path)
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, target := range fixture.Links {
+		link := filepath.Join(root, path)
+		if err = os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replayer := util.NewCommandReplayer(fixture.Commands)
+	previousRunner := util.SetCommandRunner(replayer)
+	previousRoot := linux.SetFsRoot(root)
+	t.Cleanup(func() {
+		util.SetCommandRunner(previousRunner)
+		linux.SetFsRoot(previousRoot)
+	})
+	return root, replayer
+}
+
+// checkHostState verifies that the commands run were all captured and that the sysfs entries hold
+// the expected content
+func checkHostState(t *testing.T, name string, root string, replayer *util.CommandReplayer, written map[string]string) {
+	t.Helper()
+	if unexpected := replayer.Unexpected(); len(unexpected) != 0 {
+		t.Errorf("%s: commands not captured in the host fixture: %v", name, unexpected)
+	}
+	for path, expected := range written {
+		content, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Errorf("%s: %s", name, err.Error())
+			continue
+		}
+		if string(content) != expected {
+			t.Errorf("%s: expected %q in %s, got %q", name, expected, path, string(content))
+		}
+	}
+}
+
+// checkDevice compares the discovered device with the expected one
+func checkDevice(t *testing.T, name string, device *model.Device, expected expectedDevice) {
+	t.Helper()
+	var targets []string
+	for _, target := range device.IscsiTargets {
+		targets = append(targets, target.Name)
+	}
+	for _, target := range device.NvmeTargets {
+		targets = append(targets, target.Controller)
+	}
+	actual := expectedDevice{
+		serial:    device.SerialNumber,
+		mpathName: device.MpathName,
+		size:      device.Size,
+		state:     device.State,
+		slaves:    device.Slaves,
+		targets:   targets,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected device %+v, got %+v", name, expected, actual)
+	}
+}
+
+func TestGetLinuxDmDevices(t *testing.T) {
+	iqnA := "iqn.2007-11.com.nimblestorage:vola-v3b8c2a7d5e4f6a1.00000012.a0b4b575"
+	iqnB := "iqn.2007-11.com.nimblestorage:volb-v3b8c2a7d5e4f6a1.00000013.f9e8d7c6"
+	testCases := []struct {
+		name           string
+		host           string
+		vol            *model.Volume
+		needActivePath bool
+		devices        []expectedDevice
+		wantErr        bool
+		written        map[string]string
+	}{
+		{
+			name: "all iscsi devices",
+			host: "iscsi-vst",
+			vol:  &model.Volume{},
+			devices: []expectedDevice{
+				{"d23d4c5e7a7bb51d6c9ce90075b5b4a0", "mpatha", 1024, "active", []string{"sdb", "sdc"}, []string{iqnA, iqnA}},
+				{"a1b2c3d4e5f60718293a4b5c6d7e8f90", "mpathb", 10240, "failed", []string{"sdd"}, []string{iqnB}},
+			},
+		},
+		{
+			name:           "iscsi device by serial",
+			host:           "iscsi-vst",
+			vol:            &model.Volume{SerialNumber: "D23D4C5E7A7BB51D6C9CE90075B5B4A0"},
+			needActivePath: true,
+			devices: []expectedDevice{
+				{"d23d4c5e7a7bb51d6c9ce90075b5b4a0", "mpatha", 1024, "active", []string{"sdb", "sdc"}, []string{iqnA, iqnA}},
+			},
+		},
+		{
+			name:           "iscsi device without active path",
+			host:           "iscsi-vst",
+			vol:            &model.Volume{SerialNumber: "a1b2c3d4e5f60718293a4b5c6d7e8f90"},
+			needActivePath: true,
+		},
+		{
+			name: "fc device with lun id",
+			host: "fc-gst",
+			vol:  &model.Volume{SerialNumber: "60002ac0000000000000000c0001a2b3", LunID: "1"},
+			devices: []expectedDevice{
+				{"60002ac0000000000000000c0001a2b3", "mpathc", 102400, "active", []string{"sde", "sdf"}, nil},
+			},
+		},
+		{
+			name:    "fc device remapped to another lun id",
+			host:    "fc-gst",
+			vol:     &model.Volume{SerialNumber: "60002ac0000000000000000c0001a2b3", LunID: "2"},
+			wantErr: true,
+			written: map[string]string{
+				"/sys/class/scsi_device/6:0:0:1/device/delete": "1",
+				"/sys/class/scsi_device/7:0:0:1/device/delete": "1",
+			},
+		},
+		{
+			name:           "nvme namespace active paths",
+			host:           "nvme-native",
+			vol:            &model.Volume{},
+			needActivePath: true,
+			devices: []expectedDevice{
+				{"6f8e2ad0c5a34b5f8e3d1c9b7a6f5e4d", "nvme0n1", 2048, "active", []string{"nvme0c0n1"}, []string{"nvme0", "nvme1"}},
+			},
+		},
+		{
+			name: "nvme namespace by serial",
+			host: "nvme-native",
+			vol:  &model.Volume{SerialNumber: "6F8E2AD0C5A34B5F8E3D1C9B7A6F5E4D"},
+			devices: []expectedDevice{
+				{"6F8E2AD0C5A34B5F8E3D1C9B7A6F5E4D", "nvme0n1", 2048, "active", []string{"nvme0c0n1", "nvme0c1n1"}, []string{"nvme0", "nvme1"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		root, replayer := loadHostFixture(t, tc.host)
+		devices, err := linux.GetLinuxDmDevices(tc.needActivePath, tc.vol)
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if len(devices) != len(tc.devices) {
+			t.Errorf("%s: expected %d devices, got %d", tc.name, len(tc.devices), len(devices))
+		} else {
+			for i, device := range devices {
+				checkDevice(t, tc.name, device, tc.devices[i])
+			}
+		}
+		checkHostState(t, tc.name, root, replayer, tc.written)
+	}
+}
+
+func TestLinuxDeleteDevice(t *testing.T) {
+	testCases := []struct {
+		name     string
+		host     string
+		serial   string
+		commands []string
+		written  map[string]string
+	}{
+		{
+			name:   "iscsi volume scoped target",
+			host:   "iscsi-vst",
+			serial: "d23d4c5e7a7bb51d6c9ce90075b5b4a0",
+			commands: []string{
+				"dmsetup message mpatha 0 fail_if_no_path",
+				"dmsetup remove --force mpatha",
+				"iscsiadm --mode node -u -T iqn.2007-11.com.nimblestorage:vola-v3b8c2a7d5e4f6a1.00000012.a0b4b575",
+				"iscsiadm --mode node -o delete -T iqn.2007-11.com.nimblestorage:vola-v3b8c2a7d5e4f6a1.00000012.a0b4b575",
+			},
+			// paths are removed by the target logout
+			written: map[string]string{
+				"/sys/block/sdb/device/delete": "",
+				"/sys/block/sdc/device/delete": "",
+			},
+		},
+		{
+			name:   "fc paths with LUKS mapping",
+			host:   "fc-gst",
+			serial: "60002ac0000000000000000c0001a2b3",
+			commands: []string{
+				"cryptsetup luksClose enc-mpathc",
+				"dmsetup message mpathc 0 fail_if_no_path",
+				"dmsetup remove --force mpathc",
+			},
+			written: map[string]string{
+				"/sys/block/sde/device/delete": "1\n",
+				"/sys/block/sdf/device/delete": "1\n",
+			},
+		},
+		{
+			name:     "nvme subsystem without other namespaces",
+			host:     "nvme-native",
+			serial:   "6f8e2ad0c5a34b5f8e3d1c9b7a6f5e4d",
+			commands: []string{"blockdev --flushbufs /dev/nvme0n1"},
+			written: map[string]string{
+				"/sys/class/nvme/nvme0/delete_controller": "1",
+				"/sys/class/nvme/nvme1/delete_controller": "1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		root, replayer := loadHostFixture(t, tc.host)
+		devices, err := linux.GetLinuxDmDevices(false, &model.Volume{SerialNumber: tc.serial})
+		if err != nil || len(devices) != 1 {
+			t.Errorf("%s: expected device %s, got %d devices, err %v", tc.name, tc.serial, len(devices), err)
+			continue
+		}
+		if err = linux.DeleteDevice(devices[0]); err != nil {
+			t.Errorf("%s: %s", tc.name, err.Error())
+		}
+		calls := make(map[string]bool)
+		for _, call := range replayer.Calls() {
+			calls[call] = true
+		}
+		for _, command := range tc.commands {
+			if !calls[command] {
+				t.Errorf("%s: expected command %q to be run", tc.name, command)
+			}
+		}
+		checkHostState(t, tc.name, root, replayer, tc.written)
+	}
+}
+
+// TestLinuxDeleteDeviceInUse checks that a device whose LUKS mapping is held, eg by LVM, is not
+// deleted and that its LUKS mapping is left open
+func TestLinuxDeleteDeviceInUse(t *testing.T) {
+	root, replayer := loadHostFixture(t, "fc-gst")
+	if err := os.MkdirAll(filepath.Join(root, "/sys/block/dm-3/holders"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../dm-4", filepath.Join(root, "/sys/block/dm-3/holders/dm-4")); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := linux.GetLinuxDmDevices(false, &model.Volume{SerialNumber: "60002ac0000000000000000c0001a2b3"})
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("expected a single device, got %d devices, err %v", len(devices), err)
+	}
+	if err = linux.DeleteDevice(devices[0]); err == nil {
+		t.Error("expected the delete of a device in use to be refused")
+	}
+	for _, call := range replayer.Calls() {
+		if call == "cryptsetup luksClose enc-mpathc" || call == "dmsetup remove --force mpathc" {
+			t.Errorf("expected the device in use to be left untouched, got %q", call)
+		}
+	}
+	checkHostState(t, "device in use", root, replayer, map[string]string{
+		"/sys/block/sde/device/delete": "",
+		"/sys/block/sdf/device/delete": "",
+	})
+}
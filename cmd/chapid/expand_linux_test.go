@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/chapi2/driver"
+)
+
+const (
+	xfsSerial     = "d5a8f3e1c9b7a6420c6c9ce900a1b2c3"
+	xfsMountPoint = "/mnt/vol1"
+)
+
+// TestExpandMountedXfsDevice checks that the XFS file system of a mounted device is grown through
+// its mount point, XFS cannot be grown while unmounted
+func TestExpandMountedXfsDevice(t *testing.T) {
+	root, replayer := loadHostFixture(t, "xfs-mounted")
+	chapiServer := &driver.ChapiServer{}
+	mounts, err := chapiServer.GetAllMountDetails(xfsSerial, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 || mounts[0].MountPoint != xfsMountPoint {
+		t.Fatalf("expected the device to be mounted on %s, got %d mounts", xfsMountPoint, len(mounts))
+	}
+
+	device, err := chapiServer.ExpandDevice(xfsSerial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.SerialNumber != xfsSerial || device.Size != 41943040*512 {
+		t.Errorf("expected device %s of 20GiB, got %s of %d bytes", xfsSerial, device.SerialNumber, device.Size)
+	}
+	var grown bool
+	for _, call := range replayer.Calls() {
+		grown = grown || call == "xfs_growfs "+xfsMountPoint
+	}
+	if !grown {
+		t.Errorf("expected the file system to be grown through %s, commands run %v", xfsMountPoint, replayer.Calls())
+	}
+	checkHostState(t, "expand mounted xfs device", root, replayer, map[string]string{
+		"/sys/block/sdg/device/rescan": "1",
+		"/sys/block/sdh/device/rescan": "1",
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpe-storage/common-host-libs/linux"
+	"github.com/hpe-storage/common-host-libs/model"
+)
+
+// ueventMessage returns the message broadcast by the kernel for the uevent of the device
+func ueventMessage(action string, devpath string, subsystem string) []byte {
+	return []byte(fmt.Sprintf("%s@%s\x00ACTION=%s\x00DEVPATH=%s\x00SUBSYSTEM=%s\x00DEVNAME=%s\x00DEVTYPE=disk\x00SEQNUM=4711\x00",
+		action, devpath, action, devpath, subsystem, filepath.Base(devpath)))
+}
+
+// sendUevent parses the uevent message and hands it over to the inventory
+func sendUevent(t *testing.T, inventory *linux.DeviceInventory, action string, devpath string, subsystem string) {
+	t.Helper()
+	event, err := linux.ParseUevent(ueventMessage(action, devpath, subsystem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory.HandleUevent(event)
+}
+
+func TestParseUevent(t *testing.T) {
+	event, err := linux.ParseUevent(ueventMessage(linux.UeventChange, "/devices/virtual/block/dm-0", "block"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := linux.Uevent{
+		Action:    linux.UeventChange,
+		Devpath:   "/devices/virtual/block/dm-0",
+		Subsystem: "block",
+		Devtype:   "disk",
+		Devname:   "dm-0",
+	}
+	if event.Action != expected.Action || event.Devpath != expected.Devpath || event.Subsystem != expected.Subsystem ||
+		event.Devtype != expected.Devtype || event.Devname != expected.Devname || event.Env["SEQNUM"] != "4711" {
+		t.Errorf("expected uevent %+v, got %+v", expected, *event)
+	}
+
+	for _, msg := range []string{
+		// udev rebroadcasts the uevents with a binary header
+		"libudev\x00\xfe\xed\xca\xfe(\x00\x00\x00",
+		"add@/devices/virtual/block/dm-0\x00SUBSYSTEM=block\x00",
+		"",
+	} {
+		if event, err = linux.ParseUevent([]byte(msg)); err == nil {
+			t.Errorf("expected uevent %q to be rejected, got %+v", msg, *event)
+		}
+	}
+}
+
+func TestDeviceInventory(t *testing.T) {
+	serialA := "d23d4c5e7a7bb51d6c9ce90075b5b4a0"
+	serialB := "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	root, replayer := loadHostFixture(t, "iscsi-vst")
+	inventory := linux.NewDeviceInventory()
+	if inventory.Synced() {
+		t.Error("expected inventory not to be synced before discovery")
+	}
+	if err := inventory.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !inventory.Synced() {
+		t.Error("expected inventory to be synced after discovery")
+	}
+
+	devices := inventory.Devices()
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	// ordered by serial number
+	if devices[0].MpathName != "mpathb" || devices[1].MpathName != "mpatha" {
+		t.Errorf("expected devices mpathb and mpatha, got %s and %s", devices[0].MpathName, devices[1].MpathName)
+	}
+	lookups := []struct {
+		name      string
+		device    func() string
+		mpathName string
+	}{
+		{"serial", func() string { return mpathNameOf(inventory.GetBySerial("D23D4C5E7A7BB51D6C9CE90075B5B4A0")) }, "mpatha"},
+		{"wwid", func() string { return mpathNameOf(inventory.GetByWwid("2" + serialB)) }, "mpathb"},
+		{"hctl", func() string { return mpathNameOf(inventory.GetByHctl("4:0:0:0")) }, "mpatha"},
+		{"unknown serial", func() string { return mpathNameOf(inventory.GetBySerial("60002ac0000000000000000c0001a2b3")) }, ""},
+		{"unknown hctl", func() string { return mpathNameOf(inventory.GetByHctl("9:0:0:0")) }, ""},
+	}
+	for _, lookup := range lookups {
+		if mpathName := lookup.device(); mpathName != lookup.mpathName {
+			t.Errorf("lookup by %s: expected %q, got %q", lookup.name, lookup.mpathName, mpathName)
+		}
+	}
+	// devices returned are copies
+	devices[1].Slaves[0] = "sdz"
+	if device := inventory.GetBySerial(serialA); device.Slaves[0] != "sdb" {
+		t.Errorf("expected inventory not to be altered, got slaves %v", device.Slaves)
+	}
+
+	// uevents of other subsystems are ignored
+	changesA := inventory.Changes(serialA)
+	sendUevent(t, inventory, linux.UeventChange, "/devices/platform/host3/session1/iscsi_session/session1", "iscsi_session")
+	if changes := inventory.Changes(serialA); changes != changesA {
+		t.Errorf("expected no change of %s on iscsi_session uevent, got %d changes instead of %d", serialA, changes, changesA)
+	}
+
+	// waiters are woken up by the uevent of the map
+	result := make(chan uint64, 1)
+	go func() {
+		result <- inventory.WaitForChange(serialA, changesA, 5*time.Second)
+	}()
+	sendUevent(t, inventory, linux.UeventChange, "/devices/virtual/block/dm-0", "block")
+	select {
+	case changes := <-result:
+		if changes <= changesA {
+			t.Errorf("expected %s to change after %d changes, got %d", serialA, changesA, changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("waiter not woken up by the uevent of dm-0")
+	}
+	if changes := inventory.WaitForChange(serialB, inventory.Changes(serialB), 10*time.Millisecond); changes != inventory.Changes(serialB) {
+		t.Errorf("expected no change of %s, got %d changes", serialB, changes)
+	}
+
+	// uevent of a path refreshes its map
+	changesB := inventory.Changes(serialB)
+	sendUevent(t, inventory, linux.UeventChange, "/devices/platform/host5/session3/target5:0:0/5:0:0:0/block/sdd", "block")
+	if changes := inventory.Changes(serialB); changes != changesB+1 {
+		t.Errorf("expected %s to change on uevent of sdd, got %d changes instead of %d", serialB, changes, changesB+1)
+	}
+
+	// removed map
+	if err := os.RemoveAll(filepath.Join(root, "/sys/block/dm-0")); err != nil {
+		t.Fatal(err)
+	}
+	sendUevent(t, inventory, linux.UeventRemove, "/devices/virtual/block/dm-0", "block")
+	if device := inventory.GetBySerial(serialA); device != nil {
+		t.Errorf("expected %s to be removed, got %+v", serialA, *device)
+	}
+	if device := inventory.GetByHctl("3:0:0:0"); device != nil {
+		t.Errorf("expected paths of %s to be removed, got %+v", serialA, *device)
+	}
+	if devices = inventory.Devices(); len(devices) != 1 || devices[0].MpathName != "mpathb" {
+		t.Errorf("expected device mpathb only, got %d devices", len(devices))
+	}
+	checkHostState(t, "device inventory", root, replayer, nil)
+}
+
+func TestDeviceInventoryNvme(t *testing.T) {
+	serial := "6f8e2ad0c5a34b5f8e3d1c9b7a6f5e4d"
+	root, replayer := loadHostFixture(t, "nvme-native")
+	inventory := linux.NewDeviceInventory()
+	if err := inventory.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	device := inventory.GetByWwid("EUI.6F8E2AD0C5A34B5F8E3D1C9B7A6F5E4D")
+	if device == nil || device.Pathname != "nvme0n1" {
+		t.Fatalf("expected namespace nvme0n1, got %+v", device)
+	}
+	if device = inventory.GetBySerial("6f8e2ad0-c5a3-4b5f-8e3d-1c9b7a6f5e4d"); device == nil {
+		t.Error("expected namespace to be found by its NGUID")
+	}
+
+	// uevent of a path refreshes the namespace
+	changes := inventory.Changes(serial)
+	sendUevent(t, inventory, linux.UeventChange, "/devices/virtual/nvme-fabrics/ctl/nvme1/nvme0c1n1", "block")
+	if current := inventory.Changes(serial); current != changes+1 {
+		t.Errorf("expected %s to change on uevent of nvme0c1n1, got %d changes instead of %d", serial, current, changes+1)
+	}
+	checkHostState(t, "nvme device inventory", root, replayer, nil)
+}
+
+// mpathNameOf returns the map name of the device, "" if there is none
+func mpathNameOf(device *model.Device) string {
+	if device == nil {
+		return ""
+	}
+	return device.MpathName
+}
+
+// TestUeventListener checks that a pending Receive returns once the listener is closed, on hosts
+// where the uevent socket can be opened
+func TestUeventListener(t *testing.T) {
+	listener, err := linux.NewUeventListener()
+	if err != nil {
+		t.Skipf("uevent socket not available, err %s", err.Error())
+	}
+	result := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := listener.Receive(); err != nil {
+				result <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	listener.Close()
+	select {
+	case err = <-result:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("expected receive to fail with %v, got %v", os.ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("receive not unblocked by close")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/linux"
+	"github.com/hpe-storage/common-host-libs/model"
+)
+
+const (
+	volaTarget = "iqn.2007-11.com.nimblestorage:vola-v3b8c2a7d5e4f6a1.00000012.a0b4b575"
+	volbTarget = "iqn.2007-11.com.nimblestorage:volb-v3b8c2a7d5e4f6a1.00000013.f9e8d7c6"
+)
+
+func TestGetIscsiSessions(t *testing.T) {
+	root, replayer := loadHostFixture(t, "iscsi-vst")
+	sessions, err := linux.GetIscsiSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*model.IscsiHostSession{
+		{ID: "1", TargetName: volaTarget, Tag: "2460", Address: "10.1.1.10", Port: "3260", Iface: "default", Host: "3", State: "LOGGED_IN",
+			Luns: []*model.IscsiLun{{Hctl: "3:0:0:0", LunID: "0", Device: "sdb"}}},
+		{ID: "2", TargetName: volaTarget, Tag: "2460", Address: "10.1.2.10", Port: "3260", Iface: "default", Host: "4", State: "LOGGED_IN",
+			Luns: []*model.IscsiLun{{Hctl: "4:0:0:0", LunID: "0", Device: "sdc"}}},
+		{ID: "3", TargetName: volbTarget, Tag: "2460", Address: "10.1.1.10", Port: "3260", Iface: "default", Host: "5", State: "FAILED",
+			Luns: []*model.IscsiLun{{Hctl: "5:0:0:0", LunID: "0", Device: "sdd"}}},
+	}
+	if len(sessions) != len(expected) {
+		t.Fatalf("expected %d sessions, got %d", len(expected), len(sessions))
+	}
+	for i, session := range sessions {
+		if session.InitiatorName != "iqn.1994-05.com.redhat:8f3c2b1a9d7e" || session.RecoveryTimeout != 120 {
+			t.Errorf("session %s: expected initiator and recovery timeout of 120s, got %q and %d", session.ID, session.InitiatorName, session.RecoveryTimeout)
+		}
+		session.InitiatorName, session.RecoveryTimeout = "", 0
+		if !reflect.DeepEqual(session, expected[i]) {
+			t.Errorf("expected session %+v, got %+v", *expected[i], *session)
+		}
+	}
+
+	// logged in targets include the sessions being recovered, not the ones logging out
+	targets, err := linux.GetLoggedInIscsiTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []string{volaTarget, volbTarget}) {
+		t.Errorf("expected logged in targets %s and %s, got %v", volaTarget, volbTarget, targets)
+	}
+	freeState := filepath.Join(root, "/sys/devices/platform/host5/session3/iscsi_session/session3/state")
+	if err = ioutil.WriteFile(freeState, []byte("FREE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if targets, _ = linux.GetLoggedInIscsiTargets(); !reflect.DeepEqual(targets, []string{volaTarget}) {
+		t.Errorf("expected logged in target %s only, got %v", volaTarget, targets)
+	}
+
+	// portals with devices discovered
+	if err = os.RemoveAll(filepath.Join(root, "/sys/devices/platform/host4/session2/target4:0:0")); err != nil {
+		t.Fatal(err)
+	}
+	iscsiTargets, err := linux.GetIscsiTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var portals []string
+	for _, target := range iscsiTargets {
+		portals = append(portals, target.Name+" "+target.Address+":"+target.Port+","+target.Tag)
+	}
+	expectedPortals := []string{volaTarget + " 10.1.1.10:3260,2460", volbTarget + " 10.1.1.10:3260,2460"}
+	if !reflect.DeepEqual(portals, expectedPortals) {
+		t.Errorf("expected target portals %v, got %v", expectedPortals, portals)
+	}
+	checkHostState(t, "iscsi sessions", root, replayer, nil)
+}
+
+func TestGetIscsiSessionsWithoutTransport(t *testing.T) {
+	root, replayer := loadHostFixture(t, "fc-gst")
+	sessions, err := linux.GetIscsiSessions()
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("expected no sessions, got %d, err %v", len(sessions), err)
+	}
+	if nodes, err := linux.GetIscsiNodes(); err != nil || len(nodes) != 0 {
+		t.Errorf("expected no nodes, got %d, err %v", len(nodes), err)
+	}
+	checkHostState(t, "iscsi sessions without transport", root, replayer, nil)
+}
+
+func TestGetIscsiNodes(t *testing.T) {
+	root, replayer := loadHostFixture(t, "iscsi-vst")
+	nodes, err := linux.GetIscsiNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []linux.IscsiNode{
+		{TargetName: volaTarget, Address: "10.1.1.10", Port: "3260", Tag: "2460", Iface: "default", Startup: "automatic",
+			AuthMethod: "CHAP", ChapUser: "chapuser1", ChapPassword: "chapsecret12345"},
+		{TargetName: volaTarget, Address: "10.1.2.10", Port: "3260", Tag: "2460", Iface: "default", Startup: "automatic",
+			AuthMethod: "CHAP", ChapUser: "chapuser1", ChapPassword: "chapsecret12345"},
+		{TargetName: volbTarget, Address: "10.1.1.10", Port: "3260", Tag: "2460", Iface: "default", Startup: "automatic",
+			AuthMethod: "None"},
+	}
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(nodes))
+	}
+	for i, node := range nodes {
+		if *node != expected[i] {
+			t.Errorf("expected node %+v, got %+v", expected[i], *node)
+		}
+	}
+
+	targets, err := linux.GetIscsiNodesFromIsciadm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 || targets[1].Name != volaTarget || targets[1].Address != "10.1.2.10" {
+		t.Errorf("expected the 3 portals of the node database, got %d", len(targets))
+	}
+	checkHostState(t, "iscsi nodes", root, replayer, nil)
+}
+
+func TestGetIscsiInitiators(t *testing.T) {
+	root, replayer := loadHostFixture(t, "iscsi-vst")
+	chapInfo, err := linux.GetChapInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapInfo == nil || chapInfo.Name != "chapuser1" || chapInfo.Password != "chapsecret12345" {
+		t.Errorf("expected chap user chapuser1, got %+v", chapInfo)
+	}
+
+	initiators, err := linux.GetInitiators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iscsiInitiator *model.Initiator
+	for _, initiator := range initiators {
+		if initiator.Type == "iscsi" {
+			iscsiInitiator = initiator
+		}
+	}
+	if iscsiInitiator == nil || !reflect.DeepEqual(iscsiInitiator.Init, []string{"iqn.1994-05.com.redhat:8f3c2b1a9d7e"}) ||
+		iscsiInitiator.Chap == nil || iscsiInitiator.Chap.Name != "chapuser1" {
+		t.Errorf("expected iscsi initiator with chap user chapuser1, got %+v", iscsiInitiator)
+	}
+
+	// chap disabled
+	conf := filepath.Join(root, linux.IscsiConf)
+	if err = ioutil.WriteFile(conf, []byte("node.session.auth.authmethod = None\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if chapInfo, err = linux.GetChapInfo(); err != nil || chapInfo != nil {
+		t.Errorf("expected no chap info, got %+v, err %v", chapInfo, err)
+	}
+	checkHostState(t, "iscsi initiators", root, replayer, nil)
+}
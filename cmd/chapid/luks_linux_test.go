@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/linux/luks"
+	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/util"
+)
+
+// stdinRunner replays the commands and keeps what they received on stdin
+type stdinRunner struct {
+	replayer *util.CommandReplayer
+	stdin    map[string][]string
+}
+
+func (r *stdinRunner) Run(cmd string, args []string, stdinArgs []string, timeout int) (string, int, error) {
+	r.stdin[cmd+" "+strings.Join(args, " ")] = stdinArgs
+	return r.replayer.Run(cmd, args, stdinArgs, timeout)
+}
+
+// cryptsetupRecord returns the record of a cryptsetup command
+func cryptsetupRecord(rc int, output string, args ...string) *util.CommandRecord {
+	record := &util.CommandRecord{Cmd: "cryptsetup", Args: args, Output: output, Rc: rc}
+	if rc != 0 {
+		record.Err = fmt.Sprintf("command cryptsetup failed with rc=%d err=%s", rc, output)
+	}
+	return record
+}
+
+func TestLuksKeyLifecycle(t *testing.T) {
+	dev := "/dev/loop7"
+	backup := filepath.Join(t.TempDir(), "loop7.header")
+	oneSlot := "LUKS header information for /dev/loop7\n\nVersion:       \t1\nKey Slot 0: ENABLED\n\tIterations:         \t1000\nKey Slot 1: DISABLED\n"
+	twoSlots := "LUKS header information for /dev/loop7\n\nVersion:       \t1\nKey Slot 0: ENABLED\n\tIterations:         \t1000\nKey Slot 1: ENABLED\n\tIterations:         \t1000\nKey Slot 2: DISABLED\n"
+	runner := &stdinRunner{
+		replayer: util.NewCommandReplayer([]*util.CommandRecord{
+			cryptsetupRecord(1, "Device /dev/loop7 is not a valid LUKS device.\n", "isLuks", "-v", dev),
+			cryptsetupRecord(0, "", "isLuks", "-v", dev),
+			cryptsetupRecord(0, "", "luksFormat", "--type", luks.DefaultType, "--batch-mode", dev),
+			cryptsetupRecord(4, "/dev/mapper/enc-loop7 is inactive.\n", "status", "enc-loop7"),
+			cryptsetupRecord(0, "/dev/mapper/enc-loop7 is active.\n", "status", "enc-loop7"),
+			cryptsetupRecord(0, "", "luksOpen", dev, "enc-loop7"),
+			cryptsetupRecord(0, oneSlot, "luksDump", dev),
+			cryptsetupRecord(0, twoSlots, "luksDump", dev),
+			cryptsetupRecord(0, "", "luksAddKey", dev),
+			cryptsetupRecord(0, "", "luksRemoveKey", dev),
+			cryptsetupRecord(0, "", "luksChangeKey", dev),
+			cryptsetupRecord(0, "", "luksHeaderBackup", dev, "--header-backup-file", backup),
+			cryptsetupRecord(0, "", "luksClose", "enc-loop7"),
+		}),
+		stdin: make(map[string][]string),
+	}
+	previousRunner := util.SetCommandRunner(runner)
+	defer util.SetCommandRunner(previousRunner)
+
+	mappedName := luks.MappedName("loop7")
+	steps := []struct {
+		name    string
+		run     func() error
+		wantErr bool
+	}{
+		{"format new device", func() error { return luks.Format(dev, "secret-1") }, false},
+		{"format LUKS device", func() error { return luks.Format(dev, "secret-1") }, true},
+		{"open", func() error { return luks.Open(dev, mappedName, "secret-1") }, false},
+		{"open active mapping", func() error { return luks.Open(dev, mappedName, "secret-1") }, false},
+		{"remove last key", func() error { return luks.RemoveKey(dev, "secret-1") }, true},
+		{"add key", func() error { return luks.AddKey(dev, "secret-1", "secret-2") }, false},
+		{"remove key", func() error { return luks.RemoveKey(dev, "secret-1") }, false},
+		{"rotate key", func() error { return luks.RotateKey(dev, "secret-2", "secret-3") }, false},
+		{"backup header", func() error { return luks.BackupHeader(dev, backup) }, false},
+		{"close", func() error { return luks.Close(mappedName) }, false},
+	}
+	for _, step := range steps {
+		err := step.run()
+		if step.wantErr != (err != nil) {
+			t.Errorf("%s: expected error %v, got %v", step.name, step.wantErr, err)
+		}
+	}
+
+	if unexpected := runner.replayer.Unexpected(); len(unexpected) != 0 {
+		t.Errorf("unexpected commands %v", unexpected)
+	}
+	if unreplayed := runner.replayer.Unreplayed(); len(unreplayed) != 0 {
+		t.Errorf("commands not run %v", unreplayed)
+	}
+	// keys are passed on stdin only
+	expectedStdin := map[string][]string{
+		"cryptsetup luksFormat --type luks1 --batch-mode /dev/loop7": {"secret-1"},
+		"cryptsetup luksOpen /dev/loop7 enc-loop7":                   {"secret-1"},
+		"cryptsetup luksAddKey /dev/loop7":                           {"secret-1", "secret-2"},
+		"cryptsetup luksRemoveKey /dev/loop7":                        {"secret-1"},
+		"cryptsetup luksChangeKey /dev/loop7":                        {"secret-2", "secret-3"},
+	}
+	for command, stdin := range expectedStdin {
+		if !reflect.DeepEqual(runner.stdin[command], stdin) {
+			t.Errorf("%s: expected %v on stdin, got %v", command, stdin, runner.stdin[command])
+		}
+	}
+	for _, call := range runner.replayer.Calls() {
+		if strings.Contains(call, "secret") {
+			t.Errorf("key passed as argument of %s", call)
+		}
+	}
+}
+
+func TestLuksKeyProviders(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile := func(name string, content string, mode os.FileMode) string {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	privateKeyFile := writeKeyFile("private", "secret-file\n", 0600)
+	sharedKeyFile := writeKeyFile("shared", "secret-file\n", 0644)
+	writeKeyFile("d23d4c5e7a7bb51d6c9ce90075b5b4a0.key", "secret-serial", 0400)
+	writeKeyFile("a1b2c3d4e5f60718293a4b5c6d7e8f90.key", "", 0600)
+
+	testCases := []struct {
+		name     string
+		provider luks.KeyProvider
+		volume   *model.Volume
+		key      string
+		wantErr  bool
+	}{
+		{"inline key", luks.VolumeKeyProvider{}, &model.Volume{EncryptionKey: "secret-inline"}, "secret-inline", false},
+		{"key file", luks.VolumeKeyProvider{}, &model.Volume{EncryptionKeyFile: privateKeyFile}, "secret-file", false},
+		{"key file readable by others", luks.VolumeKeyProvider{}, &model.Volume{EncryptionKeyFile: sharedKeyFile}, "", true},
+		{"missing key file", luks.VolumeKeyProvider{}, &model.Volume{EncryptionKeyFile: filepath.Join(dir, "missing")}, "", true},
+		{"not encrypted", luks.VolumeKeyProvider{}, &model.Volume{}, "", false},
+		{"key file of serial", luks.FileKeyProvider{Dir: dir}, &model.Volume{SerialNumber: "D23D4C5E7A7BB51D6C9CE90075B5B4A0"}, "secret-serial", false},
+		{"empty key file of serial", luks.FileKeyProvider{Dir: dir}, &model.Volume{SerialNumber: "a1b2c3d4e5f60718293a4b5c6d7e8f90"}, "", true},
+		{"no key file of serial", luks.FileKeyProvider{Dir: dir}, &model.Volume{SerialNumber: "60002ac0000000000000000c0001a2b3"}, "", false},
+	}
+	for _, tc := range testCases {
+		previousProvider := luks.SetKeyProvider(tc.provider)
+		key, err := luks.GetKey(tc.volume)
+		luks.SetKeyProvider(previousProvider)
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if key != tc.key {
+			t.Errorf("%s: expected key %q, got %q", tc.name, tc.key, key)
+		}
+	}
+
+	for _, volume := range []*model.Volume{
+		{EncryptionKey: "secret-inline", EncryptionKeyFile: privateKeyFile},
+		{EncryptionKeyFile: "keys/private"},
+	} {
+		if err := luks.ValidateVolume(volume); err == nil {
+			t.Errorf("expected volume %+v to be rejected", volume)
+		}
+	}
+}
+
+// TestLuksLoopDevice runs the key lifecycle against a loop device, as root on hosts with cryptsetup
+func TestLuksLoopDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("loop devices require root")
+	}
+	for _, command := range []string{"cryptsetup", "losetup"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s is not installed", command)
+		}
+	}
+
+	image := filepath.Join(t.TempDir(), "luks.img")
+	file, err := os.Create(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Truncate(32 * 1024 * 1024)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := util.ExecCommandOutput("losetup", []string{"--find", "--show", image})
+	if err != nil {
+		t.Skipf("unable to setup a loop device, err %s", err.Error())
+	}
+	dev := strings.TrimSpace(out)
+	defer util.ExecCommandOutput("losetup", []string{"--detach", dev})
+
+	mappedName := luks.MappedName(filepath.Base(dev))
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"format", func() error { return luks.Format(dev, "loop-secret-1") }},
+		{"open", func() error { return luks.Open(dev, mappedName, "loop-secret-1") }},
+		{"add key", func() error { return luks.AddKey(dev, "loop-secret-1", "loop-secret-2") }},
+		{"remove key", func() error { return luks.RemoveKey(dev, "loop-secret-1") }},
+		{"rotate key", func() error { return luks.RotateKey(dev, "loop-secret-2", "loop-secret-3") }},
+		{"backup header", func() error { return luks.BackupHeader(dev, image+".header") }},
+		{"close", func() error { return luks.Close(mappedName) }},
+		{"open with rotated key", func() error { return luks.Open(dev, mappedName, "loop-secret-3") }},
+		{"close again", func() error { return luks.Close(mappedName) }},
+	}
+	for _, step := range steps {
+		if err = step.run(); err != nil {
+			luks.Close(mappedName)
+			t.Fatalf("%s: %s", step.name, err.Error())
+		}
+	}
+	slots, err := luks.KeySlots(dev)
+	if err != nil || len(slots) != 1 {
+		t.Errorf("expected a single key slot, got %v, err %v", slots, err)
+	}
+}
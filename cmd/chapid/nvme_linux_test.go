@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpe-storage/common-host-libs/linux"
+	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/util"
+)
+
+const (
+	nvmeHostNQN   = "nqn.2014-08.org.nvmexpress:uuid:5c1e8a3b-2d4f-4e6a-9b7c-0d1e2f3a4b5c"
+	nvmeHostID    = "5c1e8a3b-2d4f-4e6a-9b7c-0d1e2f3a4b5c"
+	nvmeSubsysNQN = "nqn.2020-07.com.hpe:0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"
+	// nvmetRoot configfs tree of the kernel NVMe target
+	nvmetRoot = "/sys/kernel/config/nvmet"
+)
+
+func TestNvmeConnect(t *testing.T) {
+	root, replayer := loadHostFixture(t, "nvme-native")
+	hostNQN, err := linux.GetNvmeHostNQN()
+	if err != nil || hostNQN != nvmeHostNQN {
+		t.Errorf("expected host NQN %s, got %q, err %v", nvmeHostNQN, hostNQN, err)
+	}
+	initiators, err := linux.GetInitiators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initiators) != 1 || initiators[0].Type != "nvmetcp" || initiators[0].Init[0] != nvmeHostNQN {
+		t.Errorf("expected the nvmetcp initiator %s only, got %d initiators", nvmeHostNQN, len(initiators))
+	}
+
+	// already connected through nvme0, nothing is written to the fabrics device
+	connected := &model.NvmeTarget{NQN: nvmeSubsysNQN, Transport: "tcp", Address: "10.1.1.20", Port: "4420"}
+	if err = linux.NvmeConnect(connected); err != nil {
+		t.Fatal(err)
+	}
+	checkHostState(t, "connected nvme target", root, replayer, map[string]string{"/dev/nvme-fabrics": ""})
+
+	target := &model.NvmeTarget{NQN: nvmeSubsysNQN, Transport: "tcp", Address: "10.1.3.20", Port: "4420"}
+	if err = linux.NvmeConnect(target); err != nil {
+		t.Fatal(err)
+	}
+	if err = linux.NvmeDisconnect("nvme1"); err != nil {
+		t.Fatal(err)
+	}
+	// controllers already deleted are ignored
+	if err = linux.NvmeDisconnect("nvme7"); err != nil {
+		t.Error(err)
+	}
+	options := fmt.Sprintf("nqn=%s,transport=tcp,traddr=10.1.3.20,trsvcid=4420,hostnqn=%s,hostid=%s", nvmeSubsysNQN, nvmeHostNQN, nvmeHostID)
+	checkHostState(t, "nvme connect", root, replayer, map[string]string{
+		"/dev/nvme-fabrics":                       options,
+		"/sys/class/nvme/nvme1/delete_controller": "1",
+	})
+}
+
+// writeNvmetAttr writes an attribute of the kernel NVMe target
+func writeNvmetAttr(t *testing.T, path string, value string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		t.Fatalf("unable to set %s, err %s", path, err.Error())
+	}
+}
+
+// waitForNvmeController returns the controller connected to the subsystem, nil if none shows up in time
+func waitForNvmeController(t *testing.T, nqn string, connected bool) *model.NvmeTarget {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		controllers, err := linux.GetNvmeControllers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var controller *model.NvmeTarget
+		for _, c := range controllers {
+			if c.NQN == nqn {
+				controller = c
+			}
+		}
+		if (controller != nil) == connected {
+			return controller
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("nvme controller of %s not %s in time", nqn, map[bool]string{true: "connected", false: "deleted"}[connected])
+	return nil
+}
+
+// TestNvmeLoopback discovers, connects and disconnects a subsystem exported through a loopback
+// nvmet TCP port, as root on hosts with the nvmet, nvmet-tcp and nvme-tcp modules
+func TestNvmeLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("nvmet requires root")
+	}
+	for _, module := range []string{"nvmet", "nvmet-tcp", "nvme-tcp"} {
+		util.ExecCommandOutput("modprobe", []string{module})
+	}
+	if _, err := os.Stat(nvmetRoot); err != nil {
+		t.Skipf("nvmet is not available, err %s", err.Error())
+	}
+	if _, err := os.Stat("/dev/nvme-fabrics"); err != nil {
+		t.Skipf("nvme-tcp is not available, err %s", err.Error())
+	}
+	if _, err := exec.LookPath("losetup"); err != nil {
+		t.Skip("losetup is not installed")
+	}
+
+	// namespace backed by a loop device
+	image := filepath.Join(t.TempDir(), "nvmet.img")
+	file, err := os.Create(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Truncate(16 * 1024 * 1024)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := util.ExecCommandOutput("losetup", []string{"--find", "--show", image})
+	if err != nil {
+		t.Skipf("unable to setup a loop device, err %s", err.Error())
+	}
+	dev := strings.TrimSpace(out)
+	defer util.ExecCommandOutput("losetup", []string{"--detach", dev})
+
+	nqn := "nqn.2020-07.com.hpe:chapid-loopback-test"
+	port := "4711"
+	subsysPath := filepath.Join(nvmetRoot, "subsystems", nqn)
+	namespacePath := filepath.Join(subsysPath, "namespaces", "1")
+	portPath := filepath.Join(nvmetRoot, "ports", port)
+	portSubsysPath := filepath.Join(portPath, "subsystems", nqn)
+	if err = os.Mkdir(subsysPath, 0755); err != nil {
+		t.Skipf("unable to create nvmet subsystem, err %s", err.Error())
+	}
+	defer os.Remove(subsysPath)
+	writeNvmetAttr(t, filepath.Join(subsysPath, "attr_allow_any_host"), "1")
+	if err = os.Mkdir(namespacePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(namespacePath)
+	writeNvmetAttr(t, filepath.Join(namespacePath, "device_path"), dev)
+	writeNvmetAttr(t, filepath.Join(namespacePath, "enable"), "1")
+	defer ioutil.WriteFile(filepath.Join(namespacePath, "enable"), []byte("0"), 0644)
+	if err = os.Mkdir(portPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(portPath)
+	writeNvmetAttr(t, filepath.Join(portPath, "addr_trtype"), "tcp")
+	writeNvmetAttr(t, filepath.Join(portPath, "addr_adrfam"), "ipv4")
+	writeNvmetAttr(t, filepath.Join(portPath, "addr_traddr"), "127.0.0.1")
+	writeNvmetAttr(t, filepath.Join(portPath, "addr_trsvcid"), port)
+	if err = os.Symlink(subsysPath, portSubsysPath); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(portSubsysPath)
+
+	target := &model.NvmeTarget{NQN: nqn, Transport: "tcp", Address: "127.0.0.1", Port: port}
+	if _, err = exec.LookPath("nvme"); err == nil {
+		targets, err := linux.NvmeDiscovery("127.0.0.1", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var discovered bool
+		for _, target := range targets {
+			discovered = discovered || target.NQN == nqn
+		}
+		if !discovered {
+			t.Errorf("expected %s to be discovered, got %d subsystems", nqn, len(targets))
+		}
+	}
+
+	if err = linux.NvmeConnect(target); err != nil {
+		t.Fatal(err)
+	}
+	controller := waitForNvmeController(t, nqn, true)
+	defer linux.NvmeDisconnect(controller.Controller)
+	if controller.Address != "127.0.0.1" || controller.Port != port {
+		t.Errorf("expected controller at 127.0.0.1:%s, got %s:%s", port, controller.Address, controller.Port)
+	}
+	// connected already
+	if err = linux.NvmeConnect(target); err != nil {
+		t.Error(err)
+	}
+	if err = linux.NvmeDisconnect(controller.Controller); err != nil {
+		t.Fatal(err)
+	}
+	waitForNvmeController(t, nqn, false)
+}
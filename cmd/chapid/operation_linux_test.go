@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hpe-storage/common-host-libs/chapi"
+	log "github.com/hpe-storage/common-host-libs/logger"
+	"github.com/hpe-storage/common-host-libs/model"
+)
+
+const (
+	// operationFixtureDir holds the operations persisted by a previous chapid run
+	operationFixtureDir = "testdata/operations"
+
+	operationHostID   = "c2f1e4a7-3b6d-4f08-9e51-7a2d6c8b0e34"
+	interruptedOpID   = "0b7c5a52-8f6e-4c1d-9a57-2f1c8e4d3a10"
+	expiredOpID       = "5e2d9c1b-7a43-4f8e-8b16-c0d9e8f7a6b5"
+	operationWaitTime = 5 * time.Second
+)
+
+// operationDriver attaches and deletes the devices in memory
+type operationDriver struct {
+	*chapi.FakeDriver
+	// failSerial is the serial number of the volume which fails to attach
+	failSerial string
+	// release, if set, blocks the attach until closed
+	release chan struct{}
+
+	lock     sync.Mutex
+	attached []string
+	deleted  []string
+}
+
+func (d *operationDriver) GetHosts() (*model.Hosts, error) {
+	return &model.Hosts{&model.Host{UUID: operationHostID}}, nil
+}
+
+func (d *operationDriver) CreateDevices(volumes []*model.Volume) ([]*model.Device, error) {
+	if d.release != nil {
+		<-d.release
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var devices []*model.Device
+	for _, vol := range volumes {
+		if vol.SerialNumber == d.failSerial {
+			return nil, fmt.Errorf("unable to attach device for volume %s", vol.Name)
+		}
+		d.attached = append(d.attached, vol.SerialNumber)
+		devices = append(devices, &model.Device{SerialNumber: vol.SerialNumber})
+	}
+	return devices, nil
+}
+
+func (d *operationDriver) DeleteDevice(device *model.Device) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.deleted = append(d.deleted, device.SerialNumber)
+	return nil
+}
+
+// serveOperations serves the CHAPI requests from the given driver, with the operations persisted in
+// a temporary directory, and returns the server and a client polling it
+func serveOperations(t *testing.T, d chapi.Driver) (*httptest.Server, *chapi.Client) {
+	t.Helper()
+	previousDriver := chapi.SetDriver(d)
+	previousDir := chapi.OperationsDir
+	previousInterval := chapi.OperationPollInterval
+	chapi.OperationsDir = t.TempDir()
+	chapi.OperationPollInterval = 10 * time.Millisecond
+	chapi.LoadOperations()
+
+	operationServer := httptest.NewServer(chapi.NewRouter())
+	t.Cleanup(func() {
+		operationServer.Close()
+		// the operations still running use the driver and the directory
+		chapi.WaitForOperations()
+		chapi.SetDriver(previousDriver)
+		chapi.OperationsDir = previousDir
+		chapi.OperationPollInterval = previousInterval
+	})
+	port := operationServer.Listener.Addr().(*net.TCPAddr).Port
+	client, err := chapi.NewChapiHTTPClient("http://127.0.0.1", uint64(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return operationServer, client
+}
+
+// TestLoadOperations checks that the operations interrupted by a chapid restart are reported as
+// failed, and that the expired and invalid operations are removed
+func TestLoadOperations(t *testing.T) {
+	operationServer, client := serveOperations(t, &operationDriver{})
+	fixtures, err := ioutil.ReadDir(operationFixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		data, err := ioutil.ReadFile(filepath.Join(operationFixtureDir, fixture.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(chapi.OperationsDir, fixture.Name()), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chapi.LoadOperations()
+
+	op, err := client.GetOperation(interruptedOpID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedError := "operation was interrupted by a chapid restart at stage: attaching device for volume vol2"
+	if op.State != model.OperationFailed.String() || op.Error != expectedError {
+		t.Errorf("expected the interrupted operation to have failed with %q, got %s with %q", expectedError, op.State, op.Error)
+	}
+	if op.RequestID != "3f1d2c4b-5a69-4e87-b0c1-d2e3f4a5b6c7" {
+		t.Errorf("expected the request id of the interrupted operation to be kept, got %q", op.RequestID)
+	}
+	var persisted model.Operation
+	data, err := ioutil.ReadFile(filepath.Join(chapi.OperationsDir, interruptedOpID+".json"))
+	if err == nil {
+		err = json.Unmarshal(data, &persisted)
+	}
+	if err != nil || persisted.State != model.OperationFailed.String() {
+		t.Errorf("expected the interrupted operation to be persisted as failed, got %q, err %v", persisted.State, err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/hosts/%s/operations/%s", operationServer.URL, operationHostID, expiredOpID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for the expired operation, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	for _, name := range []string{expiredOpID + ".json", "truncated.json"} {
+		if _, err = os.Stat(filepath.Join(chapi.OperationsDir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected operation file %s to be removed, err %v", name, err)
+		}
+	}
+}
+
+// TestCreateDevicesAsync checks that an asynchronous attach is accepted with the operation running it,
+// and that the attached devices are the result of the operation
+func TestCreateDevicesAsync(t *testing.T) {
+	operationServer, client := serveOperations(t, &operationDriver{})
+	vols := []*model.Volume{
+		{Name: "vol1", SerialNumber: "6d1a8c3f0e9b47a26c9ce900b1c2d3e4"},
+		{Name: "vol2", SerialNumber: "6d1a8c3f0e9b47a26c9ce900b1c2d3e5"},
+	}
+	body, err := json.Marshal(vols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/hosts/%s/devices?async=true", operationServer.URL, operationHostID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	var op *model.Operation
+	if err = json.NewDecoder(resp.Body).Decode(&chapi.Response{Data: &op}); err != nil || op == nil {
+		t.Fatalf("unable to decode the accepted operation, err %v", err)
+	}
+	if op.Type != chapi.OperationCreateDevices || op.State != model.OperationPending.String() {
+		t.Errorf("expected a pending %s operation, got a %s %s operation", chapi.OperationCreateDevices, op.State, op.Type)
+	}
+	location := fmt.Sprintf("/hosts/%s/operations/%s", operationHostID, op.ID)
+	if resp.Header.Get("Location") != location {
+		t.Errorf("expected location %s, got %s", location, resp.Header.Get("Location"))
+	}
+
+	var devices []*model.Device
+	op, err = client.WaitForOperation(op.ID, operationWaitTime, &devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.State != model.OperationSucceeded.String() || op.Progress != 100 {
+		t.Errorf("expected the operation to have succeeded, got %s at %d%%", op.State, op.Progress)
+	}
+	if len(devices) != len(vols) || devices[0].SerialNumber != vols[0].SerialNumber || devices[1].SerialNumber != vols[1].SerialNumber {
+		t.Errorf("expected the devices of volumes vol1 and vol2, got %d devices", len(devices))
+	}
+}
+
+// TestCreateDevicesAsyncFailure checks that the error of a failed attach is reported by the operation,
+// and that the devices attached earlier in the batch are removed
+func TestCreateDevicesAsyncFailure(t *testing.T) {
+	d := &operationDriver{failSerial: "6d1a8c3f0e9b47a26c9ce900b1c2d3e5"}
+	_, client := serveOperations(t, d)
+	op, err := client.AttachDeviceAsync([]*model.Volume{
+		{Name: "vol1", SerialNumber: "6d1a8c3f0e9b47a26c9ce900b1c2d3e4"},
+		{Name: "vol2", SerialNumber: d.failSerial},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err = client.WaitForOperation(op.ID, operationWaitTime, nil)
+	if err == nil || !strings.Contains(err.Error(), "unable to attach device for volume vol2") {
+		t.Errorf("expected the attach of vol2 to fail, err %v", err)
+	}
+	if op == nil || op.State != model.OperationFailed.String() {
+		t.Errorf("expected the operation to have failed, got %+v", op)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.deleted) != 1 || d.deleted[0] != d.attached[0] {
+		t.Errorf("expected device %v attached before the failure to be removed, removed %v", d.attached, d.deleted)
+	}
+}
+
+// TestWaitForOperationTimeout checks that waiting for an operation times out while it is running,
+// without affecting the operation
+func TestWaitForOperationTimeout(t *testing.T) {
+	d := &operationDriver{release: make(chan struct{})}
+	_, client := serveOperations(t, d)
+	op, err := client.AttachDeviceAsync([]*model.Volume{{Name: "vol1", SerialNumber: "6d1a8c3f0e9b47a26c9ce900b1c2d3e4"}})
+	if err != nil {
+		close(d.release)
+		t.Fatal(err)
+	}
+	running, err := client.WaitForOperation(op.ID, 50*time.Millisecond, nil)
+	close(d.release)
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for operation "+op.ID) {
+		t.Errorf("expected the wait for operation %s to time out, err %v", op.ID, err)
+	}
+	if running == nil || running.IsDone() {
+		t.Errorf("expected the operation to still be running, got %+v", running)
+	}
+
+	var devices []*model.Device
+	if _, err = client.WaitForOperation(op.ID, operationWaitTime, &devices); err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].SerialNumber != "6d1a8c3f0e9b47a26c9ce900b1c2d3e4" {
+		t.Errorf("expected the device of volume vol1, got %d devices", len(devices))
+	}
+}
+
+// TestOperationRequestID checks that the chapi client propagates the request identifier carried by
+// its context, and that the operation records the identifier of the request which started it
+func TestOperationRequestID(t *testing.T) {
+	_, client := serveOperations(t, &operationDriver{})
+	vols := []*model.Volume{{Name: "vol1", SerialNumber: "6d1a8c3f0e9b47a26c9ce900b1c2d3e4"}}
+
+	ctx := log.ContextWithRequestID(context.Background(), "dockerplugin-42")
+	op, err := client.WithContext(ctx).AttachDeviceAsync(vols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.RequestID != "dockerplugin-42" {
+		t.Errorf("expected the operation to record the propagated request id, got %q", op.RequestID)
+	}
+
+	op, err = client.AttachDeviceAsync(vols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.RequestID == "dockerplugin-42" || !log.IsValidRequestID(op.RequestID) {
+		t.Errorf("expected the operation to record a new request id, got %q", op.RequestID)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/hpe-storage/common-host-libs/logger"
+	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/util"
+)
+
+func TestScrubber(t *testing.T) {
+	masked := log.ScrubbedValue
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			"iscsiadm chap password",
+			[]string{"-m", "node", "-T", "iqn", "-o", "update", "-n", "node.session.auth.password", "-v", "s3cret"},
+			[]string{"-m", "node", "-T", "iqn", "-o", "update", "-n", "node.session.auth.password", "-v", masked},
+		},
+		{
+			"iscsiadm chap user",
+			[]string{"-m", "node", "-o", "update", "-n", "node.session.auth.username", "-v", "chapuser1", "-T", "iqn"},
+			[]string{"-m", "node", "-o", "update", "-n", "node.session.auth.username", "-v", masked, "-T", "iqn"},
+		},
+		{
+			"key=value",
+			[]string{"--password=x", "--host=array1", "accessKey=abc"},
+			[]string{"--password=" + masked, "--host=array1", "accessKey=" + masked},
+		},
+		{
+			"value following the flag",
+			[]string{"login", "--username", "admin", "--password", "x", "--insecure"},
+			[]string{"login", "--username", masked, "--password", masked, "--insecure"},
+		},
+		{
+			"json body",
+			[]string{"-d", `{"name":"vol1","chap_password":"s3cret","size":10}`},
+			[]string{"-d", `{"chap_password":"` + masked + `","name":"vol1","size":10}`},
+		},
+		{
+			"option value containing a sensitive word",
+			[]string{"mount", "-o", "user_xattr", "/dev/x", "/mnt"},
+			[]string{"mount", "-o", "user_xattr", "/dev/x", "/mnt"},
+		},
+		{
+			"no sensitive arguments",
+			[]string{"multipathd", "show", "maps"},
+			[]string{"multipathd", "show", "maps"},
+		},
+	}
+	for _, tc := range testCases {
+		args := append([]string(nil), tc.args...)
+		scrubbed := log.Scrubber(tc.args)
+		if !reflect.DeepEqual(scrubbed, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, scrubbed)
+		}
+		if !reflect.DeepEqual(tc.args, args) {
+			t.Errorf("%s: arguments modified to %v", tc.name, tc.args)
+		}
+	}
+}
+
+func TestMapScrubber(t *testing.T) {
+	scrubbed := log.MapScrubber(map[string]string{
+		"x-auth-token": "abc",
+		"host":         "array1",
+		"body":         `{"chap_user":"chapuser1"}`,
+	})
+	expected := map[string]string{
+		"x-auth-token": log.ScrubbedValue,
+		"host":         "array1",
+		"body":         `{"chap_user":"` + log.ScrubbedValue + `"}`,
+	}
+	if !reflect.DeepEqual(scrubbed, expected) {
+		t.Errorf("expected %v, got %v", expected, scrubbed)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	newVolume := func() *model.Volume {
+		return &model.Volume{
+			Name:          "vol1",
+			Chap:          &model.ChapInfo{Name: "chapuser1", Password: "chapsecret12345"},
+			EncryptionKey: "luksPassphrase42",
+			Config:        map[string]interface{}{"chapPassword": "s3cret", "nested": map[string]interface{}{"secret": []interface{}{"a", "b"}}},
+			Status:        map[string]interface{}{"body": `{"password":"s3cret","state":"online"}`},
+		}
+	}
+	volumes := struct {
+		List  []*model.Volume
+		ByKey map[string]*model.Volume
+	}{
+		List:  []*model.Volume{newVolume()},
+		ByKey: map[string]*model.Volume{"vol1": newVolume()},
+	}
+
+	scrubbed := log.Scrub(volumes)
+	data, err := json.Marshal(scrubbed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"chapuser1", "chapsecret12345", "luksPassphrase42", "s3cret", `"a"`} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%s not scrubbed from %s", secret, data)
+		}
+	}
+	for _, value := range []string{"vol1", "online"} {
+		if !strings.Contains(string(data), value) {
+			t.Errorf("%s expected to be kept in %s", value, data)
+		}
+	}
+
+	// the LUKS passphrase is redacted, not dropped
+	if !strings.Contains(string(data), `"encryption_key":"`+log.ScrubbedValue+`"`) {
+		t.Errorf("expected the encryption key to be redacted in %s", data)
+	}
+
+	// the given value is left untouched
+	if volume := volumes.List[0]; volume.Chap.Password != "chapsecret12345" || volume.Config["chapPassword"] != "s3cret" {
+		t.Errorf("expected the volume not to be modified, got %+v", *volume.Chap)
+	}
+	if volume := volumes.ByKey["vol1"]; volume.Chap.Name != "chapuser1" {
+		t.Errorf("expected the volume not to be modified, got %+v", *volume.Chap)
+	}
+
+	// fields tagged as sensitive are masked whatever their name
+	tagged := struct {
+		Key     string `json:"key" sensitive:"true"`
+		Account string `json:"user_account" sensitive:"false"`
+	}{Key: "k", Account: "acct"}
+	scrubbedTagged := reflect.ValueOf(log.Scrub(tagged))
+	if key := scrubbedTagged.Field(0).String(); key != log.ScrubbedValue {
+		t.Errorf("expected tagged field to be masked, got %q", key)
+	}
+	if account := scrubbedTagged.Field(1).String(); account != "acct" {
+		t.Errorf("expected field tagged as not sensitive to be kept, got %q", account)
+	}
+}
+
+// TestCommandRecorderScrubsArgs checks that captured hosts never hold the secrets passed as arguments
+func TestCommandRecorderScrubsArgs(t *testing.T) {
+	args := []string{"-m", "node", "-T", "iqn", "-o", "update", "-n", "node.session.auth.password", "-v", "s3cret"}
+	replayer := util.NewCommandReplayer([]*util.CommandRecord{{Cmd: "iscsiadm", Args: log.Scrubber(args)}})
+	recorder := util.NewCommandRecorder(replayer)
+	if _, _, err := recorder.Run("iscsiadm", args, nil, 0); err != nil {
+		t.Fatalf("expected the scrubbed record to be replayed, err %s", err.Error())
+	}
+	if args[len(args)-1] != "s3cret" {
+		t.Errorf("expected the arguments not to be modified, got %v", args)
+	}
+
+	file := filepath.Join(t.TempDir(), "commands.json")
+	if err := util.SaveCommandRecords(file, recorder.Records()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("secret recorded in %s", data)
+	}
+	for _, call := range replayer.Calls() {
+		if strings.Contains(call, "s3cret") {
+			t.Errorf("secret kept in the call %s", call)
+		}
+	}
+}
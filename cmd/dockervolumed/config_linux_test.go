@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/dockerplugin/handler"
+	"github.com/hpe-storage/common-host-libs/dockerplugin/plugin"
+)
+
+const (
+	// legacyConfig was accepted before the config was validated, it holds a section the plugin ignores
+	legacyConfig = `{"global": {"deleteConflictDelay": 30}, "defaults": {"sizeInGiB": "10"}, "overrides": {}, "hosts": {}}`
+	// changedLegacyConfig still holds the ignored section
+	changedLegacyConfig = `{"global": {"deleteConflictDelay": 60}, "defaults": {"sizeInGiB": "10"}, "overrides": {}, "hosts": {}}`
+	// fixedConfig is legacyConfig without the ignored section and with a new delay
+	fixedConfig = `{"global": {"deleteConflictDelay": 90}, "defaults": {"sizeInGiB": "20"}, "overrides": {"perfPolicy": "DockerDefault"}}`
+)
+
+func TestValidateConfig(t *testing.T) {
+	samples, err := filepath.Glob("config/*-volume-driver.json")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("expected the sample configs, err %v", err)
+	}
+	for _, sample := range samples {
+		data, err := ioutil.ReadFile(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = plugin.ValidateConfig(data); err != nil {
+			t.Errorf("expected sample config %s to be valid, err %v", sample, err)
+		}
+	}
+
+	testCases := []struct {
+		config   string
+		expected string
+	}{
+		{legacyConfig, "unknown section hosts"},
+		{`{"defaults": {}}`, "section global is missing"},
+		{`{"global": {"mountConflictDelay": "soon"}}`, "global.mountConflictDelay: expected"},
+		{`{"global": {"deleteConflictDelay": -1}}`, "global.deleteConflictDelay: value must not be negative"},
+		{`{"global": {}, "defaults": {"mountOptions": {"ro": true}}}`, "defaults.mountOptions: value must not be an object"},
+		{`[]`, "config must be a JSON object"},
+	}
+	for _, tc := range testCases {
+		if err := plugin.ValidateConfig([]byte(tc.config)); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected %q, err %v", tc.config, tc.expected, err)
+		}
+	}
+}
+
+// writeConfig writes the volume driver config in the plugin config directory
+func writeConfig(t *testing.T, config string) {
+	t.Helper()
+	if err := ioutil.WriteFile(plugin.ConfigBaseDir+plugin.DriverConfigFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getConfig returns the active config and status as served by the plugin
+func getConfig(t *testing.T) *handler.HPEVolumeConfigResponse {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.HPEVolumeConfig(recorder, httptest.NewRequest(http.MethodPost, "/HPEVolume.Config", nil))
+	resp := &handler.HPEVolumeConfigResponse{}
+	if err := json.NewDecoder(recorder.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestLoadHPEVolConfig checks that a config failing the validation is loaded on startup with a warning,
+// and that the changes failing the validation are rejected on reload, the previous config remaining active
+func TestLoadHPEVolConfig(t *testing.T) {
+	previousDir, previousDelay := plugin.ConfigBaseDir, plugin.DeleteConflictDelay
+	plugin.ConfigBaseDir = t.TempDir() + "/"
+	defer func() {
+		plugin.ConfigBaseDir, plugin.DeleteConflictDelay = previousDir, previousDelay
+	}()
+
+	writeConfig(t, legacyConfig)
+	if err := plugin.LoadHPEVolConfig(); err != nil {
+		t.Fatalf("expected the config accepted by earlier releases to be loaded on startup, err %v", err)
+	}
+	plugin.InitializeDeleteConflictDelay()
+	resp := getConfig(t)
+	if resp.Options == nil || resp.Options.GlobalOptions["deleteConflictDelay"] != "30" || plugin.DeleteConflictDelay != 30 {
+		t.Fatalf("expected a delete conflict delay of 30, got %+v", resp.Options)
+	}
+	if !strings.Contains(resp.Status.Warning, "unknown section hosts") || resp.Status.Err != "" {
+		t.Errorf("expected the config to be loaded with a warning, got %+v", resp.Status)
+	}
+	loadedAt := resp.Status.LoadedAt
+
+	// a change which still fails the validation is rejected
+	writeConfig(t, changedLegacyConfig)
+	if err := plugin.LoadHPEVolConfig(); err == nil || !strings.Contains(err.Error(), "unknown section hosts") {
+		t.Errorf("expected the changed config to be rejected on reload, err %v", err)
+	}
+	resp = getConfig(t)
+	if resp.Options.GlobalOptions["deleteConflictDelay"] != "30" || plugin.DeleteConflictDelay != 30 || !resp.Status.LoadedAt.Equal(loadedAt) {
+		t.Errorf("expected the config loaded on startup to remain active, got %+v", resp.Options)
+	}
+	if !strings.Contains(resp.Status.Err, "unknown section hosts") {
+		t.Errorf("expected the reason the config was rejected, got %+v", resp.Status)
+	}
+
+	// restoring the active config is not a change
+	writeConfig(t, legacyConfig)
+	if err := plugin.LoadHPEVolConfig(); err != nil {
+		t.Errorf("expected the unchanged config to be kept, err %v", err)
+	}
+	if status := plugin.GetConfigStatus(); status.Err != "" || status.Reloads != 0 {
+		t.Errorf("expected the unchanged config not to be reloaded, got %+v", status)
+	}
+
+	// the config is read while it is reloaded
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := plugin.GetUpdatedOptsFromConfig(map[string]interface{}{"size": "5"}); err != nil {
+				t.Errorf("unable to read the active config, err %v", err)
+				return
+			}
+		}
+	}()
+	writeConfig(t, fixedConfig)
+	err := plugin.LoadHPEVolConfig()
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("expected the fixed config to be reloaded, err %v", err)
+	}
+	resp = getConfig(t)
+	if resp.Options.GlobalOptions["deleteConflictDelay"] != "90" || plugin.DeleteConflictDelay != 90 || resp.Options.OverrideOptions["perfPolicy"] != "DockerDefault" {
+		t.Errorf("expected the fixed config to be active, got %+v", resp.Options)
+	}
+	if resp.Status.Warning != "" || resp.Status.Err != "" || resp.Status.Reloads != 1 {
+		t.Errorf("expected the fixed config to be reloaded without warning, got %+v", resp.Status)
+	}
+	opts, err := plugin.GetUpdatedOptsFromConfig(map[string]interface{}{})
+	if err != nil || opts["sizeInGiB"] != "20" {
+		t.Errorf("expected the default size of the fixed config, got %v, err %v", opts["sizeInGiB"], err)
+	}
+}
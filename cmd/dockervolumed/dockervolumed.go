@@ -15,6 +15,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 const (
@@ -22,6 +23,7 @@ const (
 	envUsername              = "PROVIDER_USERNAME"
 	envPassword              = "PROVIDER_PASSWORD"
 	envRemove                = "PROVIDER_REMOVE"
+	envDrainTimeout          = "DRAIN_TIMEOUT"
 	multipathConfPath        = "/etc/multipath.conf"
 	stagingConfigPath        = "/opt/hpe-storage/etc/"
 	stagingFlexVolumeBinPath = "/opt/hpe-storage/flexvolume"
@@ -58,8 +60,8 @@ func main() {
 		log.Fatalf("unable to configure docker volume plugin, err %s", err.Error())
 	}
 
-	nimbledChan := make(chan error)
-	dockerpluginChan := make(chan error)
+	nimbledChan := make(chan error, 1)
+	dockerpluginChan := make(chan error, 1)
 	// Run chapid
 	chapi.RunNimbled(nimbledChan)
 	// Run plugin
@@ -73,27 +75,38 @@ func main() {
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
-	go func() {
-		s := <-sigc
-		log.Errorf("Exiting due to signal notification.  Signal was %v.", s.String())
-		// check if we are indicated to remove array certificates as part of plugin exit
-		// this will be set from preStop hook for flexvolume plugin(daemonset)
-		exists, _, _ := util.FileExists(cleanupHookPath)
-		if exists {
-			// set remove env, so that certificates can be removed
-			os.Setenv(envRemove, "true")
-			err := cleanup()
-			if err != nil {
-				log.Errorf("unable to cleanup plugin config files and array certificates during termination of plugin, err %s", err.Error())
+	for exit := false; !exit; {
+		select {
+		case s := <-sigc:
+			if s == syscall.SIGHUP {
+				// reload the plugin configuration instead of exiting
+				log.Infof("Received SIGHUP, reloading plugin configuration")
+				if err := plugin.LoadHPEVolConfig(); err != nil {
+					log.Errorf("unable to reload plugin configuration, err %s", err.Error())
+				}
+				continue
+			}
+			log.Infof("Shutting down due to signal notification.  Signal was %v.", s.String())
+			shutdown()
+			// check if we are indicated to remove array certificates as part of plugin exit
+			// this will be set from preStop hook for flexvolume plugin(daemonset)
+			exists, _, _ := util.FileExists(cleanupHookPath)
+			if exists {
+				// set remove env, so that certificates can be removed
+				os.Setenv(envRemove, "true")
+				err := cleanup()
+				if err != nil {
+					log.Errorf("unable to cleanup plugin config files and array certificates during termination of plugin, err %s", err.Error())
+				}
 			}
+			return
+		case msg := <-nimbledChan:
+			log.Error("error on chapid socket:", msg)
+		case msg := <-dockerpluginChan:
+			log.Error("error on docker plugin socket:", msg)
 		}
-		return
-	}()
-	select {
-	case msg := <-nimbledChan:
-		log.Error("error on chapid socket:", msg)
-	case msg := <-dockerpluginChan:
-		log.Error("error on docker plugin socket:", msg)
+		shutdown()
+		exit = true
 	}
 	// cleanup dory binary copied as well
 	err = os.RemoveAll("/usr/libexec/kubernetes/kubelet-plugins/volume/exec/hpe.com~" + plugin.GetPluginType().String())
@@ -103,6 +116,34 @@ func main() {
 	log.Info("Successfully cleaned up plugin config files, dory and array certificates")
 }
 
+// getDrainTimeout returns the time to wait for in-flight requests to complete on shutdown
+func getDrainTimeout() time.Duration {
+	drainTimeout := os.Getenv(envDrainTimeout)
+	if drainTimeout == "" {
+		return chapi.DefaultDrainTimeout
+	}
+	timeout, err := time.ParseDuration(drainTimeout)
+	if err != nil {
+		log.Errorf("invalid %s %s, using default %v, err %s", envDrainTimeout, drainTimeout, chapi.DefaultDrainTimeout, err.Error())
+		return chapi.DefaultDrainTimeout
+	}
+	return timeout
+}
+
+// shutdown stops accepting new requests on the plugin and chapid sockets and waits for in-flight
+// requests to drain
+func shutdown() {
+	drainTimeout := getDrainTimeout()
+	err := dockerplugin.ShutdownNimbledockerd(drainTimeout)
+	if err != nil {
+		log.Errorf("unable to gracefully shutdown docker plugin daemon, err %s", err.Error())
+	}
+	err = chapi.ShutdownNimbled(drainTimeout)
+	if err != nil {
+		log.Errorf("unable to gracefully shutdown chapid, err %s", err.Error())
+	}
+}
+
 func cleanup() error {
 	// cleanup array certificates
 	err := handleCertificates()
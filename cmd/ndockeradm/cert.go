@@ -0,0 +1,151 @@
+// Copyright 2019 Hewlett Packard Enterprise Development LP
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hpe-storage/common-host-libs/cert"
+	"github.com/hpe-storage/common-host-libs/dockerplugin/provider"
+	log "github.com/hpe-storage/common-host-libs/logger"
+)
+
+const (
+	defaultValidityDays = 3650
+	defaultWarnDays     = 30
+	day                 = 24 * time.Hour
+)
+
+// addCertOptionFlags adds the flags configuring generated host certificates to the subcommand
+func addCertOptionFlags(cmd *flag.FlagSet) (validityDays *int, keyType *string) {
+	validityDays = cmd.Int("validity-days", defaultValidityDays, "VALIDITY OF THE HOST CERTIFICATE IN DAYS")
+	keyType = cmd.String("key-type", string(cert.KeyTypeRSA2048), fmt.Sprintf("KEY TYPE OF THE HOST CERTIFICATE %v", cert.KeyTypes))
+	return validityDays, keyType
+}
+
+// getCertOptions returns the options of generated host certificates
+func getCertOptions(validityDays int, keyTypeName string) (*cert.Options, error) {
+	if validityDays <= 0 {
+		return nil, fmt.Errorf("invalid validity of %d days", validityDays)
+	}
+	keyType, err := cert.ParseKeyType(keyTypeName)
+	if err != nil {
+		return nil, err
+	}
+	return &cert.Options{Validity: time.Duration(validityDays) * day, KeyType: keyType}, nil
+}
+
+func parseCert(args []string) {
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	rotateCmd := flag.NewFlagSet("rotate", flag.ExitOnError)
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	// status subcommand flag pointers
+	warnDays := statusCmd.Int("warn-days", defaultWarnDays, "WARN IF A CERTIFICATE EXPIRES WITHIN DAYS")
+
+	// rotate subcommand flag pointers
+	ipAddressRotate := rotateCmd.String("ipaddress", "", "GROUP MANAGEMEMT IP")
+	usernameRotate := rotateCmd.String("username", "", "GROUP USERNAME.")
+	passwordRotate := rotateCmd.String("password", "", "GROUP PASSWORD, PROMPTED FOR OR READ FROM STDIN IF NOT PROVIDED")
+	validityDays, keyType := addCertOptionFlags(rotateCmd)
+
+	// verify subcommand flag pointers
+	ipAddressVerify := verifyCmd.String("ipaddress", "", "GROUP MANAGEMEMT IP")
+	fingerprint := verifyCmd.String("fingerprint", "", "SHA-256 FINGERPRINT OF THE GROUP CERTIFICATE, DEFAULTS TO THE PINNED CERTIFICATE")
+
+	if len(args) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "status":
+		statusCmd.Parse(args[1:])
+		parseCertStatus(*warnDays)
+	case "rotate":
+		rotateCmd.Parse(args[1:])
+		parseCertRotate(*ipAddressRotate, *usernameRotate, *passwordRotate, *validityDays, *keyType, rotateCmd)
+	case "verify":
+		verifyCmd.Parse(args[1:])
+		parseCertVerify(*ipAddressVerify, *fingerprint, verifyCmd)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func parseCertStatus(warnDays int) {
+	log.Trace("parseCertStatus called with ", warnDays)
+	healthy := true
+	for _, status := range provider.GetCertStatus() {
+		fmt.Printf("%s certificate %s\n", status.Name, status.File)
+		if status.Err != nil {
+			fmt.Printf("  status:      %s\n\n", status.Err.Error())
+			healthy = false
+			continue
+		}
+		info := status.Info
+		fmt.Printf("  subject:     %s\n", info.Subject)
+		fmt.Printf("  issuer:      %s\n", info.Issuer)
+		fmt.Printf("  serial:      %s\n", info.SerialNumber)
+		fmt.Printf("  key type:    %s\n", info.KeyType)
+		fmt.Printf("  fingerprint: %s\n", info.Fingerprint)
+		fmt.Printf("  not before:  %s\n", info.NotBefore.Format(time.RFC3339))
+		fmt.Printf("  not after:   %s\n", info.NotAfter.Format(time.RFC3339))
+		switch {
+		case info.IsExpired():
+			fmt.Printf("  status:      expired\n\n")
+			healthy = false
+		case info.ExpiresWithin(time.Duration(warnDays) * day):
+			fmt.Printf("  status:      expires in %d days\n\n", int(time.Until(info.NotAfter)/day))
+		default:
+			fmt.Printf("  status:      valid\n\n")
+		}
+	}
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+func parseCertRotate(ipAddress string, username string, password string, validityDays int, keyType string, rotateCmd *flag.FlagSet) {
+	log.Trace("parseCertRotate called with ", ipAddress, username)
+	if ipAddress == "" || username == "" {
+		rotateCmd.PrintDefaults()
+		os.Exit(1)
+	}
+	options, err := getCertOptions(validityDays, keyType)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if password == "" {
+		if password, err = readPassword(fmt.Sprintf("Password for %s@%s: ", username, ipAddress)); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	err = provider.RotateHostCerts(ipAddress, username, password, options)
+	if err != nil {
+		log.Error(err.Error())
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("host certificate %s rotated\n", provider.HostCertFile)
+}
+
+func parseCertVerify(ipAddress string, fingerprint string, verifyCmd *flag.FlagSet) {
+	log.Trace("parseCertVerify called with ", ipAddress, fingerprint)
+	if ipAddress == "" {
+		verifyCmd.PrintDefaults()
+		os.Exit(1)
+	}
+	err := provider.VerifyCerts(ipAddress, fingerprint)
+	if err != nil {
+		log.Error(err.Error())
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("host and group %s certificates verified\n", ipAddress)
+}
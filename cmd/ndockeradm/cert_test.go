@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpe-storage/common-host-libs/cert"
+)
+
+func TestParseKeyType(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected cert.KeyType
+	}{
+		{"rsa2048", cert.KeyTypeRSA2048},
+		{"RSA4096", cert.KeyTypeRSA4096},
+		{"ECDSA-P256", cert.KeyTypeECDSAP256},
+	}
+	for _, tc := range testCases {
+		keyType, err := cert.ParseKeyType(tc.name)
+		if err != nil || keyType != tc.expected {
+			t.Errorf("%s: expected key type %s, got %s, err %v", tc.name, tc.expected, keyType, err)
+		}
+	}
+	for _, name := range []string{"", "dsa", "ecdsa-p384"} {
+		if _, err := cert.ParseKeyType(name); err == nil {
+			t.Errorf("expected key type %q to be rejected", name)
+		}
+	}
+
+	if _, err := getCertOptions(0, "rsa2048"); err == nil {
+		t.Error("expected a validity of 0 days to be rejected")
+	}
+	options, err := getCertOptions(90, "ecdsa-p256")
+	if err != nil || options.Validity != 90*day || options.KeyType != cert.KeyTypeECDSAP256 {
+		t.Errorf("expected options of 90 days and key type %s, got %+v, err %v", cert.KeyTypeECDSAP256, options, err)
+	}
+}
+
+// TestGenerateECDSACert checks that an ECDSA certificate is signed with its own key, and that the key
+// pair written by ndockeradm verifies
+func TestGenerateECDSACert(t *testing.T) {
+	before := time.Now()
+	hostCert, keyPem, certPem, err := cert.GenerateCertWithOptions("host1", &cert.Options{
+		Validity: 48 * time.Hour,
+		KeyType:  cert.KeyTypeECDSAP256,
+		Hosts:    []string{"127.0.0.1", "host1.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostCert.SignatureAlgorithm != x509.ECDSAWithSHA256 {
+		t.Errorf("expected an ECDSA signature, got %s", hostCert.SignatureAlgorithm)
+	}
+	if err = hostCert.CheckSignatureFrom(hostCert); err != nil {
+		t.Errorf("expected a self-signed certificate, err %v", err)
+	}
+	if len(hostCert.IPAddresses) != 1 || len(hostCert.DNSNames) != 1 || hostCert.DNSNames[0] != "host1.example.com" {
+		t.Errorf("expected the certificate to be valid for 127.0.0.1 and host1.example.com, got %v and %v", hostCert.IPAddresses, hostCert.DNSNames)
+	}
+	if hostCert.NotAfter.Before(before.Add(47*time.Hour)) || hostCert.NotAfter.After(time.Now().Add(49*time.Hour)) {
+		t.Errorf("expected the certificate to be valid for 48 hours, valid until %s", hostCert.NotAfter)
+	}
+	info := cert.GetCertInfo(hostCert)
+	if info.KeyType != string(cert.KeyTypeECDSAP256) || info.Subject != "host1" || info.Issuer != "host1" {
+		t.Errorf("expected an ecdsa-p256 certificate of host1, got %+v", info)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "host.cert")
+	keyFile := filepath.Join(dir, "host.key")
+	if err = cert.WriteCertPemToFile(certPem, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err = cert.WriteCertPemToFile(keyPem, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err = cert.VerifyKeyPair(certFile, keyFile); err != nil {
+		t.Errorf("expected the generated key pair to verify, err %v", err)
+	}
+	read, err := cert.ReadCertFile(certFile)
+	if err != nil || !read.Equal(hostCert) {
+		t.Errorf("expected to read the generated certificate back, err %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	hostCert, _, _, err := cert.GenerateCertWithOptions("host1", &cert.Options{KeyType: cert.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(hostCert.Raw)
+	fingerprint := cert.Fingerprint(hostCert)
+	if strings.Replace(fingerprint, ":", "", -1) != strings.ToUpper(hex.EncodeToString(sum[:])) {
+		t.Errorf("expected the SHA-256 digest of the certificate, got %s", fingerprint)
+	}
+	if len(fingerprint) != 3*sha256.Size-1 || strings.Count(fingerprint, ":") != sha256.Size-1 {
+		t.Errorf("expected colon separated hex bytes, got %s", fingerprint)
+	}
+}
+
+func TestExpiresWithin(t *testing.T) {
+	info := &cert.Info{NotBefore: time.Now().Add(-day), NotAfter: time.Now().Add(10 * day)}
+	if info.IsExpired() {
+		t.Error("expected the certificate not to be expired")
+	}
+	if !info.ExpiresWithin(defaultWarnDays * day) {
+		t.Errorf("expected the certificate to expire within %d days", defaultWarnDays)
+	}
+	if info.ExpiresWithin(day) {
+		t.Error("expected the certificate not to expire within a day")
+	}
+
+	expired := &cert.Info{NotBefore: time.Now().Add(-10 * day), NotAfter: time.Now().Add(-day)}
+	if !expired.IsExpired() || !expired.ExpiresWithin(0) {
+		t.Error("expected the certificate to be expired")
+	}
+}
+
+// TestGetPinnedCertFromGroup checks that the certificate of a group is only returned if it matches
+// the pinned fingerprint, in any case and with or without separators
+func TestGetPinnedCertFromGroup(t *testing.T) {
+	group := httptest.NewTLSServer(http.NotFoundHandler())
+	defer group.Close()
+	host, port, err := net.SplitHostPort(group.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupCert := group.Certificate()
+	fingerprint := cert.Fingerprint(groupCert)
+
+	for _, pinned := range []string{fingerprint, strings.ToLower(strings.Replace(fingerprint, ":", "", -1))} {
+		pinnedCert, err := cert.GetPinnedCertFromGroup(host, port, pinned)
+		if err != nil {
+			t.Errorf("expected the certificate of the group to match %s, err %v", pinned, err)
+		} else if !pinnedCert.Equal(groupCert) {
+			t.Errorf("expected the certificate of the group, got %s", cert.Fingerprint(pinnedCert))
+		}
+	}
+
+	otherCert, _, _, err := cert.GenerateCertWithOptions("group2", &cert.Options{KeyType: cert.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cert.GetPinnedCertFromGroup(host, port, cert.Fingerprint(otherCert))
+	if err == nil || !strings.Contains(err.Error(), "doesn't match the pinned fingerprint") {
+		t.Errorf("expected the certificate of the group not to match the pinned fingerprint, err %v", err)
+	}
+	if _, err = cert.GetPinnedCertFromGroup(host, port, ""); err == nil {
+		t.Error("expected an empty pinned fingerprint to be rejected")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/cert"
+	"github.com/hpe-storage/common-host-libs/dockerplugin/provider"
+)
+
+const (
+	groupUsername = "admin"
+	groupPassword = "groupPassw0rd"
+	// groupPort port of the container provider of a group, groups are told apart by their address
+	groupPort = "8443"
+)
+
+// fakeGroup container provider of a group registering and removing host certificates
+type fakeGroup struct {
+	ipAddress string
+	server    *httptest.Server
+
+	lock       sync.Mutex
+	registered map[string]bool
+}
+
+// newFakeGroup starts the container provider of a group on the given loopback address
+func newFakeGroup(t *testing.T, ipAddress string) *fakeGroup {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(ipAddress, groupPort))
+	if err != nil {
+		t.Skipf("unable to listen on %s:%s, err %v", ipAddress, groupPort, err)
+	}
+	group := &fakeGroup{ipAddress: ipAddress, registered: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/container-provider"+provider.NimbleLoginURI, group.handle(true))
+	mux.HandleFunc("/container-provider"+provider.NimbleRemoveURI, group.handle(false))
+	group.server = httptest.NewUnstartedServer(mux)
+	group.server.Listener.Close()
+	group.server.Listener = listener
+	// each group presents its own certificate
+	_, keyPem, certPem, err := cert.GenerateCertWithOptions(ipAddress, &cert.Options{KeyType: cert.KeyTypeECDSAP256, Hosts: []string{ipAddress}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupKeyPair, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.server.TLS = &tls.Config{Certificates: []tls.Certificate{groupKeyPair}, ClientAuth: tls.RequestClientCert}
+	group.server.StartTLS()
+	t.Cleanup(group.server.Close)
+	return group
+}
+
+// handle registers or removes the host certificate of requests authenticated either by the group
+// credentials or by a registered host certificate
+func (group *fakeGroup) handle(register bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request provider.LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		authenticated := request.Username == groupUsername && request.Password == groupPassword
+		if request.Username == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			clientCert, _ := cert.ConvertCertToPem(r.TLS.PeerCertificates[0])
+			authenticated = group.registered[clientCert]
+		}
+		if !authenticated {
+			json.NewEncoder(w).Encode(&provider.LoginResponse{Err: "authentication failed"})
+			return
+		}
+		if register {
+			group.registered[request.Cert] = true
+		} else {
+			delete(group.registered, request.Cert)
+		}
+		json.NewEncoder(w).Encode(&provider.LoginResponse{})
+	}
+}
+
+// isRegistered returns true if the host certificate is registered with the group
+func (group *fakeGroup) isRegistered(hostCert string) bool {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	return group.registered[hostCert]
+}
+
+// certPem returns the certificate the group presents
+func (group *fakeGroup) certPem(t *testing.T) string {
+	t.Helper()
+	groupCert, err := cert.ConvertCertToPem(group.server.Certificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groupCert
+}
+
+// useTempProviderFiles keeps the host certificate, trust bundle and group registry in a temporary
+// directory
+func useTempProviderFiles(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	previous := []string{provider.HostCertFile, provider.HostKeyFile, provider.ServerCertFile, provider.GroupsFile}
+	provider.HostCertFile = filepath.Join(dir, "container_provider_host.cert")
+	provider.HostKeyFile = filepath.Join(dir, "container_provider_host.key")
+	provider.ServerCertFile = filepath.Join(dir, "container_provider_server.cert")
+	provider.GroupsFile = filepath.Join(dir, "groups.json")
+	t.Cleanup(func() {
+		provider.HostCertFile, provider.HostKeyFile, provider.ServerCertFile, provider.GroupsFile = previous[0], previous[1], previous[2], previous[3]
+	})
+}
+
+// readFile returns the content of the file, or an empty string if it doesn't exist
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// addGroups registers the host certificate with the groups
+func addGroups(t *testing.T, groups ...*fakeGroup) {
+	t.Helper()
+	for _, group := range groups {
+		if err := provider.AddGroup(group.ipAddress, groupUsername, groupPassword, &cert.Options{KeyType: cert.KeyTypeECDSAP256}); err != nil {
+			t.Fatalf("unable to add group %s, err %v", group.ipAddress, err)
+		}
+	}
+}
+
+// TestAddGroup checks that the host certificate is created with the first group and reused with the
+// next ones, and that the trust bundle holds the certificates of the registered groups only
+func TestAddGroup(t *testing.T) {
+	useTempProviderFiles(t)
+	group1 := newFakeGroup(t, "127.0.84.43")
+	group2 := newFakeGroup(t, "127.0.84.44")
+
+	addGroups(t, group1)
+	hostCert := readFile(t, provider.HostCertFile)
+	if hostCert == "" || !group1.isRegistered(hostCert) {
+		t.Fatalf("expected the host certificate to be created and registered with group %s", group1.ipAddress)
+	}
+	if err := cert.VerifyKeyPair(provider.HostCertFile, provider.HostKeyFile); err != nil {
+		t.Errorf("expected a valid host key pair, err %v", err)
+	}
+	if bundle := readFile(t, provider.ServerCertFile); bundle != group1.certPem(t) {
+		t.Errorf("expected the trust bundle to hold the certificate of group %s, got %q", group1.ipAddress, bundle)
+	}
+
+	addGroups(t, group2)
+	if readFile(t, provider.HostCertFile) != hostCert || !group2.isRegistered(hostCert) {
+		t.Errorf("expected the host certificate to be reused with group %s", group2.ipAddress)
+	}
+	expectedBundle := group1.certPem(t) + group2.certPem(t)
+	if bundle := readFile(t, provider.ServerCertFile); bundle != expectedBundle {
+		t.Errorf("expected the trust bundle to hold the certificates of both groups, got %q", bundle)
+	}
+	groups, err := provider.LoadGroups()
+	if err != nil || len(groups) != 2 || groups[0].Certificate != group1.certPem(t) || groups[1].Certificate != group2.certPem(t) {
+		t.Errorf("expected both groups to be registered with their pinned certificates, got %d groups, err %v", len(groups), err)
+	}
+
+	// a group the host certificate cannot be registered with is neither trusted nor registered
+	group3 := newFakeGroup(t, "127.0.84.45")
+	if err = provider.AddGroup(group3.ipAddress, groupUsername, "wrong", nil); err == nil {
+		t.Errorf("expected group %s to be rejected with the wrong password", group3.ipAddress)
+	}
+	if bundle := readFile(t, provider.ServerCertFile); bundle != expectedBundle {
+		t.Errorf("expected the trust bundle to be left unchanged, got %q", bundle)
+	}
+	if _, err = provider.GetGroup(group3.ipAddress); err == nil {
+		t.Errorf("expected group %s not to be registered", group3.ipAddress)
+	}
+}
+
+// TestRemoveGroup checks that a group is removed with the host certificate authenticating the
+// request, and that the host certificate is removed along with the last group
+func TestRemoveGroup(t *testing.T) {
+	useTempProviderFiles(t)
+	group1 := newFakeGroup(t, "127.0.84.43")
+	group2 := newFakeGroup(t, "127.0.84.44")
+	addGroups(t, group1, group2)
+	hostCert := readFile(t, provider.HostCertFile)
+
+	if err := provider.RemoveGroup(group1.ipAddress, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if group1.isRegistered(hostCert) || !group2.isRegistered(hostCert) {
+		t.Errorf("expected the host certificate to be removed from group %s only", group1.ipAddress)
+	}
+	if bundle := readFile(t, provider.ServerCertFile); bundle != group2.certPem(t) {
+		t.Errorf("expected the trust bundle to hold the certificate of group %s only, got %q", group2.ipAddress, bundle)
+	}
+	if _, err := provider.GetGroup(group1.ipAddress); err == nil {
+		t.Errorf("expected group %s to be removed", group1.ipAddress)
+	}
+
+	if err := provider.RemoveGroup(group2.ipAddress, groupUsername, groupPassword); err != nil {
+		t.Fatal(err)
+	}
+	if group2.isRegistered(hostCert) {
+		t.Errorf("expected the host certificate to be removed from group %s", group2.ipAddress)
+	}
+	for _, file := range []string{provider.HostCertFile, provider.HostKeyFile, provider.ServerCertFile, provider.GroupsFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed along with the last group, err %v", file, err)
+		}
+	}
+}
+
+func TestGetGroupStatus(t *testing.T) {
+	useTempProviderFiles(t)
+	group1 := newFakeGroup(t, "127.0.84.43")
+	addGroups(t, group1)
+	group, err := provider.GetGroup(group1.ipAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := provider.GetGroupStatus(group)
+	if !status.Reachable || !status.CertMatches || status.Err != nil {
+		t.Errorf("expected group %s to present its pinned certificate, got %s", group.IPAddress, status)
+	}
+	if !strings.Contains(status.String(), "certificate valid until") {
+		t.Errorf("expected the validity of the pinned certificate, got %s", status)
+	}
+
+	_, _, otherCert, err := cert.GenerateCertWithOptions("group2", &cert.Options{KeyType: cert.KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := *group
+	changed.Certificate = otherCert
+	if status = provider.GetGroupStatus(&changed); !status.Reachable || status.CertMatches || !strings.Contains(status.String(), "certificate changed") {
+		t.Errorf("expected the certificate of group %s to have changed, got %s", group.IPAddress, status)
+	}
+
+	unreachable := *group
+	unreachable.IPAddress = "127.0.84.46"
+	if status = provider.GetGroupStatus(&unreachable); status.Reachable || status.Err == nil || !strings.HasPrefix(status.String(), "unreachable") {
+		t.Errorf("expected group %s to be unreachable, got %s", unreachable.IPAddress, status)
+	}
+}
@@ -5,9 +5,11 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"text/tabwriter"
+	"time"
 
+	"github.com/hpe-storage/common-host-libs/cert"
 	"github.com/hpe-storage/common-host-libs/dockerplugin/plugin"
 	"github.com/hpe-storage/common-host-libs/dockerplugin/provider"
 	log "github.com/hpe-storage/common-host-libs/logger"
@@ -24,16 +26,19 @@ func main() {
 	// Subcommands
 	addGroupCmd := flag.NewFlagSet("add", flag.ExitOnError)
 	removeGroupCmd := flag.NewFlagSet("remove", flag.ExitOnError)
+	listGroupsCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	statusGroupsCmd := flag.NewFlagSet("status", flag.ExitOnError)
 
 	// Add Group subcommand flag pointers
 	ipAddressAdd := addGroupCmd.String("ipaddress", "", "GROUP MANAGEMEMT IP")
 	username := addGroupCmd.String("username", "", "GROUP USERNAME.")
-	password := addGroupCmd.String("password", "", "GROUP PASSWORD")
+	password := addGroupCmd.String("password", "", "GROUP PASSWORD, PROMPTED FOR OR READ FROM STDIN IF NOT PROVIDED")
+	validityDays, keyType := addCertOptionFlags(addGroupCmd)
 
 	// Remove Group subcommand flag pointers
 	ipAddressRemove := removeGroupCmd.String("ipaddress", "", "GROUP MANAGEMEMT IP.")
-	usernameRemove := removeGroupCmd.String("username", "", "GROUP USERNAME.")
-	passwordRemove := removeGroupCmd.String("password", "", "GROUP PASSWORD")
+	usernameRemove := removeGroupCmd.String("username", "", "GROUP USERNAME, NOT REQUIRED IF THE HOST CERTIFICATE IS TRUSTED BY THE GROUP")
+	passwordRemove := removeGroupCmd.String("password", "", "GROUP PASSWORD, PROMPTED FOR OR READ FROM STDIN IF NOT PROVIDED")
 
 	// override Usage
 	flag.Usage = func() {
@@ -42,6 +47,11 @@ func main() {
 		fmt.Println()
 		fmt.Printf("ndockeradm add [-ipaddress {GROUP MANAGEMEMT IP}] [-username {GROUP USERNAME}] [-password {GROUP PASSWORD}] \n")
 		fmt.Printf("ndockeradm remove [-ipaddress {GROUP MANAGEMEMT IP}] [-username {GROUP USERNAME}] [-password {GROUP PASSWORD}] \n")
+		fmt.Printf("ndockeradm list \n")
+		fmt.Printf("ndockeradm status \n")
+		fmt.Printf("ndockeradm cert status [-warn-days {DAYS}] \n")
+		fmt.Printf("ndockeradm cert rotate [-ipaddress {GROUP MANAGEMEMT IP}] [-username {GROUP USERNAME}] [-password {GROUP PASSWORD}] [-validity-days {DAYS}] [-key-type {KEY TYPE}] \n")
+		fmt.Printf("ndockeradm cert verify [-ipaddress {GROUP MANAGEMEMT IP}] [-fingerprint {SHA-256 FINGERPRINT}] \n")
 		fmt.Println()
 	}
 	// check if the necessary logs file directories are created. create if not present
@@ -74,63 +84,122 @@ func main() {
 		addGroupCmd.Parse(os.Args[2:])
 	case "remove":
 		removeGroupCmd.Parse(os.Args[2:])
+	case "list":
+		listGroupsCmd.Parse(os.Args[2:])
+	case "status":
+		statusGroupsCmd.Parse(os.Args[2:])
+	case "cert":
+		parseCert(os.Args[2:])
+		return
 	default:
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	if addGroupCmd.Parsed() {
-		parseAddGroup(*ipAddressAdd, *username, *password, addGroupCmd)
+		parseAddGroup(*ipAddressAdd, *username, *password, *validityDays, *keyType, addGroupCmd)
 	} else if removeGroupCmd.Parsed() {
 		parseRemoveGroup(*ipAddressRemove, *usernameRemove, *passwordRemove, removeGroupCmd)
+	} else if listGroupsCmd.Parsed() {
+		parseListGroups()
+	} else if statusGroupsCmd.Parsed() {
+		parseStatusGroups()
 	}
 }
 
-func parseAddGroup(ipAddressAdd string, username string, password string, addGroupCmd *flag.FlagSet) {
+func parseAddGroup(ipAddressAdd string, username string, password string, validityDays int, keyType string, addGroupCmd *flag.FlagSet) {
 	log.Trace("parseAddGroup called with ", ipAddressAdd, username)
 	// Required Flags
-	if ipAddressAdd == "" || username == "" || password == "" {
+	if ipAddressAdd == "" || username == "" {
 		addGroupCmd.PrintDefaults()
 		os.Exit(1)
 	}
 	log.Tracef("ipaddress: %s, username: %s\n", ipAddressAdd, username)
-	// set environment variable for the container-storage-provider ip
-	os.Setenv("PROVIDER_IP", ipAddressAdd)
-	os.Setenv("PROVIDER_USERNAME", username)
+	options, err := getCertOptions(validityDays, keyType)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(1)
+	}
+	if password == "" {
+		if password, err = readPassword(fmt.Sprintf("Password for %s@%s: ", username, ipAddressAdd)); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
 	// create and add certificate to the group
-	err := provider.LoginAndCreateCerts(ipAddressAdd, username, password, false)
+	err = provider.AddGroup(ipAddressAdd, username, password, options)
 	if err != nil {
 		log.Error(err.Error())
 		fmt.Printf(err.Error())
-		// delete the invalid certs
-		os.RemoveAll(DockerCertHome)
-		return
+		// delete the invalid certs, unless they are in use with other groups
+		if groups, _ := provider.LoadGroups(); len(groups) == 0 {
+			os.RemoveAll(DockerCertHome)
+		}
+		os.Exit(1)
 	}
 }
 
 func parseRemoveGroup(ipAddressRemove string, username string, password string, removeGroupCmd *flag.FlagSet) {
-	log.Trace("parseRemoveGroup called with ", ipAddressRemove)
-	if ipAddressRemove == "" || username == "" || password == "" {
+	log.Trace("parseRemoveGroup called with ", ipAddressRemove, username)
+	if ipAddressRemove == "" {
 		removeGroupCmd.PrintDefaults()
 		os.Exit(1)
 	}
 	log.Tracef("ipaddress: %s\n", ipAddressRemove)
-	hostCertPem, err := ioutil.ReadFile(provider.HostCertFile)
-	if err != nil {
-		log.Trace("unable to load host cert", err.Error())
-		fmt.Printf(err.Error())
-		return
+	var err error
+	// without a username, the host certificate trusted by the group authenticates the request
+	if username != "" && password == "" {
+		if password, err = readPassword(fmt.Sprintf("Password for %s@%s: ", username, ipAddressRemove)); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 	}
-	log.Tracef("hostCertPem %s", string(hostCertPem))
 	// invoke dockerplugin.NimbleRemoveURI end point of container provider to remove certificate
-	provider.AddRemoveCertContainerProvider(provider.NimbleRemoveURI, ipAddressRemove, string(hostCertPem), username, password)
+	err = provider.RemoveGroup(ipAddressRemove, username, password)
+	if err != nil {
+		log.Error(err.Error())
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
 
-	//unset env for container-storage-provider ip
-	os.Unsetenv("PROVIDER_IP")
-	os.Unsetenv("PROVIDER_USERNAME")
+func parseListGroups() {
+	log.Trace("parseListGroups called")
+	groups, err := provider.LoadGroups()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IP ADDRESS\tUSERNAME\tADDED")
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", group.IPAddress, group.Username, group.AddedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
 
-	//remove the cert files
-	os.RemoveAll(provider.ServerCertFile)
-	os.RemoveAll(provider.HostCertFile)
-	os.RemoveAll(provider.HostKeyFile)
+func parseStatusGroups() {
+	log.Trace("parseStatusGroups called")
+	groups, err := provider.LoadGroups()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	healthy := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	hostStatus := "valid"
+	if err = cert.VerifyKeyPair(provider.HostCertFile, provider.HostKeyFile); err != nil {
+		hostStatus = err.Error()
+		healthy = false
+	}
+	fmt.Fprintf(w, "host certificate\t%s\n", hostStatus)
+	for _, group := range groups {
+		status := provider.GetGroupStatus(group)
+		healthy = healthy && status.Err == nil && status.CertMatches && !status.CertInfo.IsExpired()
+		fmt.Fprintf(w, "group %s\t%s\n", group.IPAddress, status.String())
+	}
+	w.Flush()
+	if !healthy {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,33 @@
+// Copyright 2019 Hewlett Packard Enterprise Development LP
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// readPassword prompts for the password on the terminal without echo, or reads it from the first
+// line of stdin when stdin is not a terminal
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(password), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("unable to read password from stdin: " + err.Error())
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -34,6 +34,12 @@ const (
 	XML = "xml"
 	// JSON format output
 	JSON = "json"
+	// JUNIT JUnit XML format output
+	JUNIT = "junit"
+	// SARIF SARIF 2.1.0 format output
+	SARIF = "sarif"
+	// exit code when settings are not compliant with the --fail-on severity threshold
+	exitNonCompliant = 3
 )
 
 // Options for get sub command
@@ -42,8 +48,23 @@ type getOptions struct {
 	status   string
 	severity string
 	verbose  bool
-	json     bool
-	xml      bool
+	format   string
+}
+
+// Options for check sub command
+type checkOptions struct {
+	getOptions
+	failOn string
+}
+
+// checkReport is the XML/JSON output of the check sub command
+type checkReport struct {
+	XMLName         xml.Name                     `json:"-" xml:"report"`
+	Compliant       bool                         `json:"compliant" xml:"compliant"`
+	FailOn          string                       `json:"fail_on" xml:"fail-on"`
+	Violations      int                          `json:"violations" xml:"violations"`
+	Summary         *tunelinux.ComplianceSummary `json:"summary" xml:"summary"`
+	Recommendations []*tunelinux.Recommendation  `json:"recommendations,omitempty" xml:"recommendations>Recommendation,omitempty"`
 }
 
 // Options for set sub command
@@ -51,6 +72,8 @@ type setOptions struct {
 	category string
 	severity string
 	global   bool
+	persist  bool
+	dryRun   bool
 }
 
 // change display color for text based on compliance status
@@ -177,6 +200,57 @@ func displayCustomFormat(format string, complianceStatus string, severity string
 	}
 }
 
+// displaySummary : display compliance summary counts in tabular format
+func displaySummary(summary *tunelinux.ComplianceSummary) {
+	fmt.Println()
+	fmt.Println("Summary:")
+	fmt.Println("+------------+-----------------+-----------------+-----------------+")
+	fmt.Printf("| %-10s | %-15s | %-15s | %-15s |\n", "Category", "Severity", "Recommended", "Not-Recommended")
+	fmt.Println("+------------+-----------------+-----------------+-----------------+")
+	for _, count := range summary.Counts {
+		fmt.Printf("| %-10s | %-15s | %-15d | %-15d |\n", count.Category, count.Level, count.Recommended, count.NotRecommended)
+	}
+	fmt.Println("+------------+-----------------+-----------------+-----------------+")
+	fmt.Printf("| %-10s | %-15s | %-15d | %-15d |\n", "Total", "", summary.Recommended, summary.NotRecommended)
+	fmt.Println("+------------+-----------------+-----------------+-----------------+")
+}
+
+// displayCheckResult : display the result of the compliance check
+func displayCheckResult(failOn string, violations []*tunelinux.Recommendation) {
+	fmt.Println()
+	if len(violations) == 0 {
+		fmt.Printf("Result: PASS (no not-recommended settings with severity %s or higher)\n", failOn)
+		return
+	}
+	result := "FAIL"
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		result = fmt.Sprintf("%s%s%s", Red, result, NoColor)
+	}
+	fmt.Printf("Result: %s (%d not-recommended setting(s) with severity %s or higher)\n", result, len(violations), failOn)
+}
+
+// displayCheckReport : display recommendations along with the compliance summary in either XML/JSON format
+func displayCheckReport(format string, complianceStatus string, severity string, report *checkReport) {
+	var err error
+	var result []byte
+	for _, recommendation := range recommendations {
+		if recommendation != nil && ignoreRecommendation(complianceStatus, severity, recommendation) == false {
+			report.Recommendations = append(report.Recommendations, recommendation)
+		}
+	}
+	if format == JSON {
+		result, err = json.MarshalIndent(report, "", "\t")
+	} else if format == XML {
+		result, err = xml.MarshalIndent(report, "", "\t")
+	}
+	if err != nil {
+		log.Errorf("Unable to convert compliance report to %s error: %s\n", format, err.Error())
+		fmt.Printf("Error: Failed to convert compliance report to %s format, reason: %s\n", format, err.Error())
+		return
+	}
+	fmt.Println(string(result))
+}
+
 func getRecommendationByCategory(category string) (err error) {
 	// Get All nimble devices
 	devices, err := linux.GetLinuxDmDevices(false, util.GetVolumeObject("", ""))
@@ -189,7 +263,7 @@ func getRecommendationByCategory(category string) (err error) {
 	case tunelinux.Category.String(tunelinux.Filesystem):
 		recommendations, err = tunelinux.GetFileSystemRecommendations(devices)
 	case tunelinux.Category.String(tunelinux.Multipath):
-		recommendations, err = tunelinux.GetMultipathRecommendations()
+		recommendations, err = getNimbleMultipathRecommendations()
 	case tunelinux.Category.String(tunelinux.Disk):
 		recommendations, err = tunelinux.GetDeviceRecommendations(devices)
 	case tunelinux.Category.String(tunelinux.Iscsi):
@@ -209,21 +283,63 @@ func getRecommendationByCategory(category string) (err error) {
 	return err
 }
 
-func setRecommendationsByCategory(category string, global bool) (err error) {
-	if category == tunelinux.Category.String(tunelinux.Filesystem) || category == tunelinux.Category.String(tunelinux.Fc) {
-		err = errors.New("Only multipath/disk/iscsi categories supported for set recommendations. For others please follow the documentation as specified in the description for each recommendation setting")
+// getNimbleMultipathRecommendations returns the multipath recommendations for Nimble devices
+func getNimbleMultipathRecommendations() (recommendations []*tunelinux.Recommendation, err error) {
+	deviceRecommendations, err := tunelinux.GetMultipathRecommendations()
+	if err != nil {
+		return nil, err
+	}
+	for _, deviceRecommendation := range deviceRecommendations {
+		if deviceRecommendation.DeviceType == "Nimble" {
+			recommendations = append(recommendations, deviceRecommendation.RecomendArray...)
+		}
+	}
+	return recommendations, nil
+}
+
+func setRecommendationsByCategory(category string, global bool, persist bool, dryRun bool) (err error) {
+	// further actions required from the user to complete applying recommendations
+	var notices []string
+	apply := func() (err error) {
+		switch category {
+		case tunelinux.Category.String(tunelinux.Filesystem):
+			notices, err = tunelinux.SetFileSystemRecommendations(persist)
+		case tunelinux.Category.String(tunelinux.Fc):
+			notices, err = tunelinux.SetFcRecommendations()
+		case tunelinux.Category.String(tunelinux.Multipath):
+			err = tunelinux.SetMultipathRecommendations()
+		case tunelinux.Category.String(tunelinux.Disk):
+			err = tunelinux.SetBlockDeviceRecommendations()
+		case tunelinux.Category.String(tunelinux.Iscsi):
+			err = tunelinux.SetIscsiRecommendations(global)
+		case tunelinux.All:
+			err = tunelinux.SetRecommendations(global)
+		}
 		return err
 	}
-	switch category {
-	case tunelinux.Category.String(tunelinux.Multipath):
-		err = tunelinux.SetMultipathRecommendations()
-	case tunelinux.Category.String(tunelinux.Disk):
-		err = tunelinux.SetBlockDeviceRecommendations()
-	case tunelinux.Category.String(tunelinux.Iscsi):
-		err = tunelinux.SetIscsiRecommendations(global)
-	case tunelinux.All:
-		err = tunelinux.SetRecommendations(global)
+
+	// only display the changes that would be made
+	if dryRun {
+		diffs, err := tunelinux.PreviewChanges(apply)
+		if err != nil {
+			fmt.Printf("Failed to preview %s recommendations, error: %s\n", category, err.Error())
+			return err
+		}
+		if len(diffs) == 0 {
+			fmt.Printf("No configuration files would be changed by %s recommendations\n", category)
+		}
+		for _, diff := range diffs {
+			fmt.Print(diff.Diff)
+		}
+		displayNotices(notices)
+		return nil
 	}
+
+	changeSet, err := tunelinux.ApplyChanges(category, apply)
+	if changeSet != nil {
+		fmt.Printf("Recorded change set %s, use 'nimbletune --rollback %s' to undo\n", changeSet.ID, changeSet.ID)
+	}
+	displayNotices(notices)
 	if err != nil {
 		fmt.Printf("Failed to apply %s recommendations, error: %s\n", category, err.Error())
 		return err
@@ -232,6 +348,27 @@ func setRecommendationsByCategory(category string, global bool) (err error) {
 	return err
 }
 
+// displayNotices : display further actions required to complete applying recommendations
+func displayNotices(notices []string) {
+	for _, notice := range notices {
+		fmt.Printf("%sNote:%s %s\n", Yellow, NoColor, notice)
+	}
+}
+
+// rollbackChangeSet restores the configuration files changed by the given change set
+func rollbackChangeSet(id string) (err error) {
+	changeSet, err := tunelinux.RollbackChangeSet(id)
+	if err != nil {
+		fmt.Printf("Failed to rollback change set %s, error: %s\n", id, err.Error())
+		return err
+	}
+	for _, file := range changeSet.Files {
+		fmt.Printf("Restored %s\n", file.Path)
+	}
+	fmt.Printf("Successfully rolled back change set %s\n", id)
+	return nil
+}
+
 func validateCategory(category string) {
 	var err error
 	switch category {
@@ -267,12 +404,31 @@ func validateStatus(complianceStatus string) {
 	}
 }
 
-func validateOutputFormat(verbose bool, jsonFlag bool, xmlFlag bool) {
+func validateFailOn(failOn string) {
+	if failOn == tunelinux.All || tunelinux.GetSeverityRank(failOn) == 0 {
+		fmt.Println("Error: Invalid fail-on severity provided")
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func validateOutputFormat(verbose bool, jsonFlag bool, xmlFlag bool, format string) {
 	var err error
-	if jsonFlag == true && xmlFlag == true {
+	switch format {
+	case "":
+	case JSON:
+	case XML:
+	case JUNIT:
+	case SARIF:
+	default:
+		err = errors.New("Error: Invalid output format provided")
+	}
+	if err == nil && jsonFlag == true && xmlFlag == true {
 		err = errors.New("Error: Invalid output formats combination provided, enter either xml or json")
-	} else if verbose == true && (jsonFlag == true || xmlFlag == true) {
-		err = errors.New("Error: Invalid output format combination provided. enter only one of verbose, xml or json formats")
+	} else if err == nil && format != "" && (jsonFlag == true || xmlFlag == true) {
+		err = errors.New("Error: Invalid output formats combination provided, enter either format, xml or json")
+	} else if err == nil && verbose == true && (jsonFlag == true || xmlFlag == true || format != "") {
+		err = errors.New("Error: Invalid output format combination provided. enter only one of verbose, xml, json or format")
 	}
 	if err != nil {
 		fmt.Println(err.Error())
@@ -281,6 +437,16 @@ func validateOutputFormat(verbose bool, jsonFlag bool, xmlFlag bool) {
 	}
 }
 
+// getOutputFormat returns the output format selected by either the -json, -xml or -format options
+func getOutputFormat(jsonFlag bool, xmlFlag bool, format string) string {
+	if jsonFlag == true {
+		return JSON
+	} else if xmlFlag == true {
+		return XML
+	}
+	return format
+}
+
 func validateSeverity(severity string) {
 	var err error
 	switch severity {
@@ -307,10 +473,10 @@ func handleGetRecommendations(getCommandOptions *getOptions) (err error) {
 		return err
 	}
 	// display recommendations
-	if getCommandOptions.json == true {
-		displayCustomFormat(JSON, getCommandOptions.status, getCommandOptions.severity)
-	} else if getCommandOptions.xml == true {
-		displayCustomFormat(XML, getCommandOptions.status, getCommandOptions.severity)
+	if getCommandOptions.format == JSON || getCommandOptions.format == XML {
+		displayCustomFormat(getCommandOptions.format, getCommandOptions.status, getCommandOptions.severity)
+	} else if getCommandOptions.format == JUNIT || getCommandOptions.format == SARIF {
+		displayReportFormat(getCommandOptions.format, getCommandOptions.status, getCommandOptions.severity)
 	} else if getCommandOptions.verbose == true {
 		displayVerboseFormat(getCommandOptions.status, getCommandOptions.severity)
 	} else {
@@ -319,10 +485,45 @@ func handleGetRecommendations(getCommandOptions *getOptions) (err error) {
 	return err
 }
 
+// handle check recommendations command, returns false if any not-recommended setting meets the
+// fail-on severity threshold
+func handleCheckRecommendations(checkCommandOptions *checkOptions) (compliant bool, err error) {
+	// get recommendations for category
+	err = getRecommendationByCategory(checkCommandOptions.category)
+	if err != nil {
+		return false, err
+	}
+	// evaluate compliance before the display adds color to the compliance status
+	summary := tunelinux.GetComplianceSummary(recommendations)
+	violations := tunelinux.GetComplianceViolations(recommendations, checkCommandOptions.failOn)
+
+	// display recommendations along with the summary
+	if checkCommandOptions.format == JSON || checkCommandOptions.format == XML {
+		report := &checkReport{
+			Compliant:  len(violations) == 0,
+			FailOn:     checkCommandOptions.failOn,
+			Violations: len(violations),
+			Summary:    summary}
+		displayCheckReport(checkCommandOptions.format, checkCommandOptions.status, checkCommandOptions.severity, report)
+	} else if checkCommandOptions.format == JUNIT || checkCommandOptions.format == SARIF {
+		// the exit status carries the check result
+		displayReportFormat(checkCommandOptions.format, checkCommandOptions.status, checkCommandOptions.severity)
+	} else {
+		if checkCommandOptions.verbose == true {
+			displayVerboseFormat(checkCommandOptions.status, checkCommandOptions.severity)
+		} else {
+			displayTabularFormat(checkCommandOptions.status, checkCommandOptions.severity)
+		}
+		displaySummary(summary)
+		displayCheckResult(checkCommandOptions.failOn, violations)
+	}
+	return len(violations) == 0, nil
+}
+
 // handle set recommendations command
 func handleSetRecomendations(setCommandOptions *setOptions) (err error) {
 	// set recommendations for category
-	err = setRecommendationsByCategory(setCommandOptions.category, setCommandOptions.global)
+	err = setRecommendationsByCategory(setCommandOptions.category, setCommandOptions.global, setCommandOptions.persist, setCommandOptions.dryRun)
 	return err
 }
 
@@ -339,29 +540,43 @@ func getVersion() (version string) {
 
 const (
 	getCommandDescription = "Get recommendations"
+	checkDescription      = "Check compliance with recommendations, exit with status 3 if not compliant"
+	failOnDescription     = "Minimum severity of not-recommended settings that fails the check {critical | error | warning | info}, default:critical. (Optional)"
 	setCommandDescription = "Set recommendations"
 	categoryDescription   = "Recommendation category {filesystem | multipath | disk | iscsi | fc | All}. (Optional)"
 	complianceDescription = "Recommendation status {recommended | not-recommended | All}. (Optional)"
 	severityDescription   = "Recommendation severity {critical | warning | info | All}. (Optional)"
 	jsonDescription       = "JSON output of recommendations. (Optional)"
 	xmlDescription        = "XML output of recommendations. (Optional)"
+	formatDescription     = "Output format of recommendations {json | xml | junit | sarif}. (Optional)"
 	verboseDescription    = "Verbose output. (Optional)"
 	versionDescription    = "Display version of the tool. (Optional)"
 	globalDescription     = "If true, settings will be configured globally at host level wherever applicable(eg iscsid.conf),default:false"
+	persistDescription    = "If true, filesystem mount options will also be added to /etc/fstab, default:false"
+	dryRunDescription     = "Display the changes to configuration files without applying them. (Optional)"
+	rollbackDescription   = "Restore configuration files changed by the given change set id"
+	overrideDescription   = "Directory of recommendation template override files, default:config.d alongside config.json. (Optional)"
 	NimbleTuneLogFile     = "/var/log/nimbletune.log"
 )
 
 var (
 	getFlag          = flag.Bool("get", false, getCommandDescription)
 	setFlag          = flag.Bool("set", false, setCommandDescription)
+	checkFlag        = flag.Bool("check", false, checkDescription)
+	failOn           = flag.String("fail-on", tunelinux.Severity.String(tunelinux.Critical), failOnDescription)
 	category         = flag.String("category", tunelinux.All, categoryDescription)
 	complianceStatus = flag.String("status", tunelinux.All, complianceDescription)
 	severity         = flag.String("severity", tunelinux.All, severityDescription)
 	verbose          = flag.Bool("verbose", false, verboseDescription)
 	jsonFlag         = flag.Bool("json", false, jsonDescription)
 	xmlFlag          = flag.Bool("xml", false, xmlDescription)
+	format           = flag.String("format", "", formatDescription)
 	versionFlag      = flag.Bool("version", false, xmlDescription)
 	global           = flag.Bool("global", false, globalDescription)
+	persist          = flag.Bool("persist", false, persistDescription)
+	dryRunFlag       = flag.Bool("dry-run", false, dryRunDescription)
+	rollback         = flag.String("rollback", "", rollbackDescription)
+	overrideDir      = flag.String("override-dir", "", overrideDescription)
 )
 
 // initialize command options for short options
@@ -380,17 +595,27 @@ func main() {
 		fmt.Printf("\nNimble Linux Tuning Utility\n")
 		fmt.Printf("\nUsage:\n")
 		fmt.Println()
-		fmt.Printf("nimbletune --get [—category {filesystem | multipath | disk | iscsi | fc | all}] [—status {recommended | not-recommended | all}] [—severity {critical | warning | info | all}] [—verbose] [-json] [-xml]\n")
-		fmt.Printf("nimbletune --set [—category {multipath | disk | iscsi | all}]\n")
+		fmt.Printf("nimbletune --get [—category {filesystem | multipath | disk | iscsi | fc | all}] [—status {recommended | not-recommended | all}] [—severity {critical | warning | info | all}] [—verbose] [-json] [-xml] [-format {json | xml | junit | sarif}]\n")
+		fmt.Printf("nimbletune --check [—category {filesystem | multipath | disk | iscsi | fc | all}] [--fail-on {critical | error | warning | info}] [—verbose] [-json] [-xml] [-format {json | xml | junit | sarif}]\n")
+		fmt.Printf("nimbletune --set [—category {filesystem | multipath | disk | iscsi | fc | all}] [--persist] [--dry-run]\n")
+		fmt.Printf("nimbletune --rollback <change set id>\n")
 		fmt.Printf("\nOptions:\n")
 		fmt.Printf("\t%-20s\t%-50s\n", "-c, -category", categoryDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-st, -status", complianceDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-sev, -severity", severityDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-fail-on", failOnDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-json", jsonDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-xml", xmlDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-format", formatDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-global", globalDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-persist", persistDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-dry-run", dryRunDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-rollback", rollbackDescription)
+		fmt.Printf("\t%-20s\t%-50s\n", "-override-dir", overrideDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-verbose", verboseDescription)
 		fmt.Printf("\t%-20s\t%-50s\n", "-v, -version", versionDescription)
+		fmt.Printf("\nExit status:\n")
+		fmt.Printf("\t0 success, 1 failure or invalid option values, 2 options could not be parsed, %d check found not-recommended settings at or above the fail-on severity\n", exitNonCompliant)
 		fmt.Println()
 	}
 
@@ -407,28 +632,63 @@ func main() {
 	} else if *versionFlag == true {
 		fmt.Println(getVersion())
 		return
-	} else if *getFlag == false && *setFlag == false {
-		fmt.Println("Please pass the get or set subcommand for recommendations")
+	} else if *rollback != "" {
+		if err = rollbackChangeSet(*rollback); err != nil {
+			os.Exit(1)
+		}
+		return
+	} else if *getFlag == false && *setFlag == false && *checkFlag == false {
+		fmt.Println("Please pass the get, check or set subcommand for recommendations")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	// layer site specific overrides on top of the recommendation templates
+	if *overrideDir != "" {
+		tunelinux.SetOverrideDir(*overrideDir)
+	}
+
 	// Check if options get/set suboptions are parsed.
-	if *getFlag == true {
+	if *checkFlag == true {
+		// get the options structure
+		checkCommandOptions := &checkOptions{
+			getOptions: getOptions{
+				category: *category,
+				status:   *complianceStatus,
+				severity: *severity,
+				verbose:  *verbose,
+				format:   getOutputFormat(*jsonFlag, *xmlFlag, *format)},
+			failOn: *failOn}
+
+		// validate input parameters
+		validateCategory(*category)
+		validateStatus(*complianceStatus)
+		validateSeverity(*severity)
+		validateFailOn(*failOn)
+		validateOutputFormat(*verbose, *jsonFlag, *xmlFlag, *format)
+		// handle check command
+		compliant, err := handleCheckRecommendations(checkCommandOptions)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if !compliant {
+			os.Exit(exitNonCompliant)
+		}
+	} else if *getFlag == true {
 		// get the options structure
 		getCommandOptions := &getOptions{
 			category: *category,
 			status:   *complianceStatus,
 			severity: *severity,
 			verbose:  *verbose,
-			json:     *jsonFlag,
-			xml:      *xmlFlag}
+			format:   getOutputFormat(*jsonFlag, *xmlFlag, *format)}
 
 		// validate input parameters
 		validateCategory(*category)
 		validateStatus(*complianceStatus)
 		validateSeverity(*severity)
-		validateOutputFormat(*verbose, *jsonFlag, *xmlFlag)
+		validateOutputFormat(*verbose, *jsonFlag, *xmlFlag, *format)
 		// handle get command
 		err = handleGetRecommendations(getCommandOptions)
 		if err != nil {
@@ -441,7 +701,9 @@ func main() {
 		setCommandOptions := &setOptions{
 			category: *category,
 			severity: *severity,
-			global:   *global}
+			global:   *global,
+			persist:  *persist,
+			dryRun:   *dryRunFlag}
 
 		validateCategory(*category)
 		validateSeverity(*severity)
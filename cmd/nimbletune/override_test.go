@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpe-storage/common-host-libs/linux"
+	"github.com/hpe-storage/common-host-libs/model"
+	"github.com/hpe-storage/common-host-libs/tunelinux"
+)
+
+const (
+	// templateConfig bundled templates the overrides are layered on
+	templateConfig = `{
+	"Nimble": {"Default": [
+		{"category": "disk", "parameter": "scheduler", "recommendation": "noop", "severity": "warning", "description": "I/O scheduler"},
+		{"category": "disk", "parameter": "nr_requests", "recommendation": "128", "severity": "critical", "description": "queue depth"}
+	]},
+	"3PARdata": {"Default": [
+		{"category": "disk", "parameter": "scheduler", "recommendation": "noop", "severity": "warning", "description": "I/O scheduler"}
+	]}
+}`
+	// strictOverride replaces a parameter missing from the 3PARdata template
+	strictOverride = `{"device_type": "3PARdata", "overrides": [{"action": "replace", "category": "disk", "parameter": "nr_requests", "recommendation": "64"}]}`
+	// invalidOverride adds a parameter without severity and description
+	invalidOverride = `{"overrides": [{"action": "add", "category": "disk", "parameter": "rq_affinity", "recommendation": "2"}]}`
+)
+
+// templateOverrideFiles layered site overrides, a file for all device types followed by a Nimble one
+var templateOverrideFiles = map[string]string{
+	"10-all.json": `{"overrides": [
+		{"action": "replace", "category": "disk", "parameter": "nr_requests", "recommendation": "512"},
+		{"action": "add", "category": "disk", "parameter": "read_ahead_kb", "recommendation": "4096", "severity": "warning", "description": "read ahead"},
+		{"action": "add", "category": "disk", "parameter": "scheduler", "recommendation": "none", "severity": "info", "description": "I/O scheduler"}
+	]}`,
+	"20-nimble.json": `{"device_type": "Nimble", "overrides": [
+		{"action": "replace", "category": "disk", "parameter": "read_ahead_kb", "recommendation": "8192"},
+		{"action": "suppress", "category": "disk", "parameter": "scheduler"}
+	]}`,
+	"README": "not an override file",
+}
+
+// writeFile writes the file, creating its directory
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getDiskRecommendations returns the block queue recommendations of the device type by parameter
+func getDiskRecommendations(t *testing.T, deviceType string) (map[string]*tunelinux.Recommendation, error) {
+	t.Helper()
+	recommendations, err := tunelinux.GetDeviceRecommendations([]*model.Device{{Minor: "0", AltFullPathName: "/dev/mapper/mpatha"}}, deviceType)
+	if err != nil {
+		return nil, err
+	}
+	byParameter := make(map[string]*tunelinux.Recommendation)
+	for _, recommendation := range recommendations {
+		byParameter[recommendation.Parameter] = recommendation
+	}
+	return byParameter, nil
+}
+
+// TestTemplateOverrides checks that the override files are layered on the bundled templates in order,
+// and that invalid overrides fail the recommendations. The templates are loaded once, so the
+// recommendations of a device type reflect the overrides present when they first load successfully.
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	overrideDir := filepath.Join(dir, "config.d")
+	writeFile(t, configFile, templateConfig)
+	for name, content := range templateOverrideFiles {
+		writeFile(t, filepath.Join(overrideDir, name), content)
+	}
+	queueSettings := map[string]string{"scheduler": "[mq-deadline] none", "nr_requests": "256", "read_ahead_kb": "8192"}
+	for param, value := range queueSettings {
+		writeFile(t, filepath.Join(dir, "sys/block/dm-0/queue", param), value+"\n")
+	}
+	previousConfigFile, previousOverrideDir := tunelinux.ConfigFile, tunelinux.GetOverrideDir()
+	tunelinux.SetConfigFile(configFile)
+	tunelinux.SetOverrideDir(overrideDir)
+	previousRoot := linux.SetFsRoot(dir)
+	defer func() {
+		tunelinux.SetConfigFile(previousConfigFile)
+		tunelinux.SetOverrideDir(previousOverrideDir)
+		linux.SetFsRoot(previousRoot)
+	}()
+
+	// invalid override files are rejected
+	writeFile(t, filepath.Join(overrideDir, "40-invalid.json"), invalidOverride)
+	if _, err := getDiskRecommendations(t, "Nimble"); err == nil || !strings.Contains(err.Error(), "recommendation, severity and description are required") {
+		t.Errorf("expected add without severity and description to be rejected, err %v", err)
+	}
+	os.Remove(filepath.Join(overrideDir, "40-invalid.json"))
+
+	// overrides of a single device type are strict
+	writeFile(t, filepath.Join(overrideDir, "30-3par.json"), strictOverride)
+	if _, err := getDiskRecommendations(t, "3PARdata"); err == nil || !strings.Contains(err.Error(), "not found for deviceType 3PARdata") {
+		t.Errorf("expected the replace of a parameter missing for 3PARdata to be rejected, err %v", err)
+	}
+	os.Remove(filepath.Join(overrideDir, "30-3par.json"))
+
+	// overrides of all device types skip the templates without the parameter replaced or already
+	// having the parameter added, later files take precedence
+	expected := map[string]map[string]tunelinux.Recommendation{
+		"Nimble": {
+			"nr_requests":   {Recommendation: "512", Level: "critical", Description: "queue depth", CompliantStatus: "not-recommended"},
+			"read_ahead_kb": {Recommendation: "8192", Level: "warning", Description: "read ahead", CompliantStatus: "recommended"},
+			"scheduler":     {Recommendation: "", Level: "", Description: "", CompliantStatus: "not-recommended"},
+		},
+		"3PARdata": {
+			"nr_requests":   {Recommendation: "", Level: "", Description: "", CompliantStatus: "not-recommended"},
+			"read_ahead_kb": {Recommendation: "4096", Level: "warning", Description: "read ahead", CompliantStatus: "not-recommended"},
+			"scheduler":     {Recommendation: "noop", Level: "warning", Description: "I/O scheduler", CompliantStatus: "not-recommended"},
+		},
+	}
+	for deviceType, settings := range expected {
+		recommendations, err := getDiskRecommendations(t, deviceType)
+		if err != nil {
+			t.Errorf("%s: %s", deviceType, err.Error())
+			continue
+		}
+		for param, setting := range settings {
+			recommendation := recommendations[param]
+			if recommendation == nil {
+				t.Errorf("%s: expected a recommendation for %s", deviceType, param)
+				continue
+			}
+			if recommendation.Recommendation != setting.Recommendation || recommendation.Level != setting.Level ||
+				recommendation.Description != setting.Description || recommendation.CompliantStatus != setting.CompliantStatus {
+				t.Errorf("%s: expected %s setting %+v, got %+v", deviceType, param, setting, *recommendation)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 Hewlett Packard Enterprise Development LP
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+
+	log "github.com/hpe-storage/common-host-libs/logger"
+	"github.com/hpe-storage/common-host-libs/tunelinux"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName    xml.Name          `xml:"testsuites"`
+	Name       string            `xml:"name,attr"`
+	Tests      int               `xml:"tests,attr"`
+	Failures   int               `xml:"failures,attr"`
+	TestSuites []*junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite groups the test cases of a recommendation category
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single recommendation
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitFailure describes a not-recommended setting
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// sarifLog is the root object of a SARIF report
+type sarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string       `json:"name"`
+	Version string       `json:"version,omitempty"`
+	Rules   []*sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifResult struct {
+	RuleID    string           `json:"ruleId"`
+	RuleIndex int              `json:"ruleIndex"`
+	Kind      string           `json:"kind"`
+	Level     string           `json:"level"`
+	Message   sarifMessage     `json:"message"`
+	Locations []*sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []*sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// getRecommendationName returns the name of the recommendation keyed by parameter and device
+func getRecommendationName(recommendation *tunelinux.Recommendation) string {
+	if recommendation.Device == "" || recommendation.Device == tunelinux.All || recommendation.Device == tunelinux.NotApplicable {
+		return recommendation.Parameter
+	}
+	return recommendation.Parameter + " (" + recommendation.Device + ")"
+}
+
+// getRecommendationDetails returns the current and recommended values of the recommendation
+func getRecommendationDetails(recommendation *tunelinux.Recommendation) string {
+	return fmt.Sprintf("value: %s, recommendation: %s", recommendation.Value, recommendation.Recommendation)
+}
+
+// isRecommended returns true if the setting matches the recommendation
+func isRecommended(recommendation *tunelinux.Recommendation) bool {
+	return recommendation.CompliantStatus == tunelinux.ComplianceStatus.String(tunelinux.Recommended)
+}
+
+// getSarifLevel maps the recommendation severity to a SARIF result level
+func getSarifLevel(severity string) string {
+	switch severity {
+	case tunelinux.Severity.String(tunelinux.Critical), tunelinux.Severity.String(tunelinux.Error):
+		return "error"
+	case tunelinux.Severity.String(tunelinux.Warning):
+		return "warning"
+	}
+	return "note"
+}
+
+// getJunitReport converts recommendations into a JUnit report, one test suite per category
+func getJunitReport(recommendations []*tunelinux.Recommendation) *junitTestSuites {
+	report := &junitTestSuites{Name: "nimbletune"}
+	testSuites := make(map[string]*junitTestSuite)
+	for _, recommendation := range recommendations {
+		testSuite, ok := testSuites[recommendation.Category]
+		if !ok {
+			testSuite = &junitTestSuite{Name: recommendation.Category}
+			testSuites[recommendation.Category] = testSuite
+			report.TestSuites = append(report.TestSuites, testSuite)
+		}
+		testCase := &junitTestCase{
+			Name:      getRecommendationName(recommendation),
+			ClassName: "nimbletune." + recommendation.Category,
+		}
+		if !isRecommended(recommendation) {
+			testCase.Failure = &junitFailure{
+				Message: recommendation.Description,
+				Type:    recommendation.Level,
+				Text:    getRecommendationDetails(recommendation),
+			}
+			testSuite.Failures++
+			report.Failures++
+		}
+		testSuite.TestCases = append(testSuite.TestCases, testCase)
+		testSuite.Tests++
+		report.Tests++
+	}
+	return report
+}
+
+// getSarifReport converts recommendations into a SARIF report, one rule per category/parameter
+func getSarifReport(recommendations []*tunelinux.Recommendation) *sarifLog {
+	// results and rules are required by the SARIF schema, even when empty
+	run := &sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "nimbletune", Version: Version, Rules: []*sarifRule{}}},
+		Results: []*sarifResult{},
+	}
+	ruleIndexes := make(map[string]int)
+	for _, recommendation := range recommendations {
+		ruleID := recommendation.Category + "/" + recommendation.Parameter
+		ruleIndex, ok := ruleIndexes[ruleID]
+		if !ok {
+			ruleIndex = len(run.Tool.Driver.Rules)
+			ruleIndexes[ruleID] = ruleIndex
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, &sarifRule{
+				ID:                   ruleID,
+				Name:                 recommendation.Parameter,
+				ShortDescription:     sarifMessage{Text: recommendation.Description},
+				DefaultConfiguration: sarifConfiguration{Level: getSarifLevel(recommendation.Level)},
+			})
+		}
+		result := &sarifResult{
+			RuleID:    ruleID,
+			RuleIndex: ruleIndex,
+			Kind:      "pass",
+			Level:     "none",
+			Message:   sarifMessage{Text: recommendation.Description + " (" + getRecommendationDetails(recommendation) + ")"},
+		}
+		if !isRecommended(recommendation) {
+			result.Kind = "fail"
+			result.Level = getSarifLevel(recommendation.Level)
+		}
+		if name := getRecommendationName(recommendation); name != recommendation.Parameter {
+			result.Locations = []*sarifLocation{{LogicalLocations: []*sarifLogicalLocation{{Name: recommendation.Device, Kind: "resource"}}}}
+		}
+		run.Results = append(run.Results, result)
+	}
+	return &sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []*sarifRun{run}}
+}
+
+// displayReportFormat : display recommendations in either JUnit/SARIF format
+func displayReportFormat(format string, complianceStatus string, severity string) {
+	var finalRecommendations []*tunelinux.Recommendation
+	var err error
+	var result []byte
+	for _, recommendation := range recommendations {
+		if recommendation != nil {
+			if ignoreRecommendation(complianceStatus, severity, recommendation) == true {
+				continue
+			}
+			finalRecommendations = append(finalRecommendations, recommendation)
+		}
+	}
+	if format == JUNIT {
+		result, err = xml.MarshalIndent(getJunitReport(finalRecommendations), "", "\t")
+		result = append([]byte(xml.Header), result...)
+	} else if format == SARIF {
+		result, err = json.MarshalIndent(getSarifReport(finalRecommendations), "", "\t")
+	}
+	if err != nil {
+		log.Errorf("Unable to convert recommendations to %s error: %s\n", format, err.Error())
+		fmt.Printf("Error: Failed to convert recommendations to %s format, reason: %s\n", format, err.Error())
+		return
+	}
+	fmt.Println(string(result))
+}
@@ -3,6 +3,7 @@ module github.com/hpe-storage/common-host-utils
 go 1.19
 
 require (
+	github.com/gorilla/mux v1.8.1
 	github.com/hpe-storage/common-host-libs v0.0.0-20240118164757-65eb0171b2ca
 	github.com/josephspurrier/goversioninfo v1.4.0
 	github.com/kardianos/service v1.2.2
@@ -15,7 +16,6 @@ require (
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/google/uuid v1.2.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/hectane/go-acl v0.0.0-20230122075934-ca0b05cb1adb // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect